@@ -72,7 +72,7 @@ resource "vix_vm" "core01" {
 
     network_adapter {
         type = "nat"
-        mac_address = "00:50:56:aa:bb:cc"
+        mac_address = "00:50:56:2a:bb:cc"
         mac_address_type = "static"
     }
 
@@ -80,9 +80,9 @@ resource "vix_vm" "core01" {
         type = "hostonly"
     }
 
-    cdrom {
-        bus_type = "scsi"
-        image = "/Users/camilo/Dropbox/Development/hooklift/boxes/cfgdrv.iso"
+    // Generates a cloud-init NoCloud seed image and attaches it as a CD/DVD
+    cloud_init {
+        user_data = "${file("user-data")}"
     }
 
     // Adds an IDE device by default and autodetects the host's CDROM
@@ -91,10 +91,13 @@ resource "vix_vm" "core01" {
     shared_folder {
         name = "Dev1"
         enable = false
-        guest_path = "/home/camilo/dev"
         host_path = "/Users/camilo/Development"
         readonly = false
     }
+
+    guestinfo = {
+        hostname = "core01"
+    }
 }
 
 output "IP address" {
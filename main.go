@@ -10,6 +10,7 @@ import (
 
 func main() {
 	plugin.Serve(&plugin.ServeOpts{
-		ProviderFunc: vix.Provider,
+		ProviderFunc:    vix.Provider,
+		ProvisionerFunc: vix.Provisioner,
 	})
 }
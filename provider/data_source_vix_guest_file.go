@@ -0,0 +1,102 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+package provider
+
+import (
+	"encoding/base64"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/helper/validation"
+	"github.com/hooklift/terraform-provider-vix/provider/vix"
+)
+
+func dataSourceVIXGuestFile() *schema.Resource {
+	return &schema.Resource{
+		Read: dataSourceVIXGuestFileRead,
+
+		Schema: map[string]*schema.Schema{
+			"vm_id": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"path": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"username": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"password": &schema.Schema{
+				Type:      schema.TypeString,
+				Optional:  true,
+				Sensitive: true,
+			},
+			"max_size": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      1024 * 1024,
+				ValidateFunc: validation.IntBetween(1, 64*1024*1024),
+			},
+			"tools_init_timeout": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "30s",
+				ValidateFunc: validateDuration,
+			},
+			"content": &schema.Schema{
+				Type:      schema.TypeString,
+				Computed:  true,
+				Sensitive: true,
+			},
+			"content_base64": &schema.Schema{
+				Type:      schema.TypeString,
+				Computed:  true,
+				Sensitive: true,
+			},
+			"size": &schema.Schema{
+				Type:     schema.TypeInt,
+				Computed: true,
+			},
+		},
+	}
+}
+
+func dataSourceVIXGuestFileRead(d *schema.ResourceData, meta interface{}) error {
+	config := meta.(*Config)
+
+	vm := new(vix.VM)
+	vm.Provider = config.Product
+	vm.VerifySSL = config.VerifySSL
+	vm.GuestUsername = d.Get("username").(string)
+	vm.GuestPassword = d.Get("password").(string)
+
+	var err error
+	vm.ToolsInitTimeout, err = time.ParseDuration(d.Get("tools_init_timeout").(string))
+	if err != nil {
+		return err
+	}
+
+	vmxFile := d.Get("vm_id").(string)
+	path := d.Get("path").(string)
+
+	session, err := vm.LoginInGuest(vmxFile)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	data, err := session.ReadFile(path, int64(d.Get("max_size").(int)))
+	if err != nil {
+		return err
+	}
+
+	d.SetId(vmxFile + ":" + path)
+	d.Set("content", string(data))
+	d.Set("content_base64", base64.StdEncoding.EncodeToString(data))
+	d.Set("size", len(data))
+
+	return nil
+}
@@ -0,0 +1,93 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+package provider
+
+import (
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hooklift/terraform-provider-vix/provider/vix"
+)
+
+func dataSourceVIXSnapshots() *schema.Resource {
+	return &schema.Resource{
+		Read: dataSourceVIXSnapshotsRead,
+
+		Schema: map[string]*schema.Schema{
+			"vm_id": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"current": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			"snapshot": &schema.Schema{
+				Type:     schema.TypeList,
+				Computed: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"name": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"description": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"parent": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"path": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"current": &schema.Schema{
+							Type:     schema.TypeBool,
+							Computed: true,
+						},
+					},
+				},
+			},
+		},
+	}
+}
+
+func dataSourceVIXSnapshotsRead(d *schema.ResourceData, meta interface{}) error {
+	vm := newSnapshotVM(meta)
+	vmxFile := d.Get("vm_id").(string)
+
+	snapshots, err := vm.Snapshots(vmxFile)
+	if err != nil {
+		return err
+	}
+
+	d.SetId(vmxFile)
+
+	return snapshots_vix_to_tf(snapshots, d)
+}
+
+// Nested attributes can not be set one by one, the whole list is set at once.
+func snapshots_vix_to_tf(snapshots []*vix.Snapshot, d *schema.ResourceData) error {
+	current := ""
+	list := make([]map[string]interface{}, 0, len(snapshots))
+	for _, snapshot := range snapshots {
+		list = append(list, map[string]interface{}{
+			"name":        snapshot.Name,
+			"description": snapshot.Description,
+			"parent":      snapshot.Parent,
+			"path":        snapshot.Path,
+			"current":     snapshot.Current,
+		})
+
+		if snapshot.Current {
+			current = snapshot.Path
+		}
+	}
+
+	if err := d.Set("snapshot", list); err != nil {
+		return err
+	}
+
+	return d.Set("current", current)
+}
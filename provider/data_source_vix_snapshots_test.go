@@ -0,0 +1,43 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+package provider
+
+import (
+	"testing"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hooklift/terraform-provider-vix/provider/vix"
+)
+
+func TestSnapshotsVIXToTF(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, dataSourceVIXSnapshots().Schema, map[string]interface{}{
+		"vm_id": "/vms/core01/core01.vmx",
+	})
+
+	err := snapshots_vix_to_tf([]*vix.Snapshot{
+		{Name: "base", Path: "base"},
+		{Name: "tools", Description: "VMware Tools installed", Parent: "base", Path: "base/tools", Current: true},
+	}, d)
+	if err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if n := d.Get("snapshot.#").(int); n != 2 {
+		t.Fatalf("expected 2 snapshots, got %d", n)
+	}
+
+	for attr, expected := range map[string]interface{}{
+		"current":                "base/tools",
+		"snapshot.0.name":        "base",
+		"snapshot.0.current":     false,
+		"snapshot.1.parent":      "base",
+		"snapshot.1.description": "VMware Tools installed",
+		"snapshot.1.path":        "base/tools",
+		"snapshot.1.current":     true,
+	} {
+		if actual := d.Get(attr); actual != expected {
+			t.Errorf("%s = %#v, expected %#v", attr, actual, expected)
+		}
+	}
+}
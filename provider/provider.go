@@ -37,8 +37,16 @@ func Provider() terraform.ResourceProvider {
 		},
 
 		ResourcesMap: map[string]*schema.Resource{
-			"vix_vm":      resourceVIXVM(),
-			"vix_vswitch": resourceVIXVSwitch(),
+			"vix_vm":        resourceVIXVM(),
+			"vix_vswitch":   resourceVIXVSwitch(),
+			"vix_snapshot":  resourceVIXSnapshot(),
+			"vix_vm_export": resourceVIXVMExport(),
+			"vix_template":  resourceVIXTemplate(),
+		},
+
+		DataSourcesMap: map[string]*schema.Resource{
+			"vix_guest_file": dataSourceVIXGuestFile(),
+			"vix_snapshots":  dataSourceVIXSnapshots(),
 		},
 
 		ConfigureFunc: providerConfigure,
@@ -0,0 +1,205 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+package provider
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/terraform"
+	"github.com/hooklift/terraform-provider-vix/provider/vix"
+)
+
+// Provisioner running commands and uploading files through VMware Tools guest
+// operations, so guests do not need to be reachable over the network. The
+// virtual machine is identified by its vmx path, given as the connection host.
+func Provisioner() terraform.ResourceProvisioner {
+	return &schema.Provisioner{
+		ConnSchema: map[string]*schema.Schema{
+			"host": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"user": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+			},
+			"password": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+			"timeout": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+		},
+
+		Schema: map[string]*schema.Schema{
+			"product": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  "workstation",
+			},
+
+			"interpreter": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  "/bin/sh",
+			},
+
+			"file": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"source": &schema.Schema{
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"destination": &schema.Schema{
+							Type:     schema.TypeString,
+							Required: true,
+						},
+					},
+				},
+			},
+
+			"inline": &schema.Schema{
+				Type:          schema.TypeList,
+				Optional:      true,
+				Elem:          &schema.Schema{Type: schema.TypeString},
+				ConflictsWith: []string{"script", "scripts"},
+			},
+
+			"script": &schema.Schema{
+				Type:          schema.TypeString,
+				Optional:      true,
+				ConflictsWith: []string{"inline", "scripts"},
+			},
+
+			"scripts": &schema.Schema{
+				Type:          schema.TypeList,
+				Optional:      true,
+				Elem:          &schema.Schema{Type: schema.TypeString},
+				ConflictsWith: []string{"inline", "script"},
+			},
+		},
+
+		ApplyFunc: provisionerVIXApply,
+	}
+}
+
+func provisionerVIXApply(ctx context.Context) error {
+	conn := ctx.Value(schema.ProvConnDataKey).(*schema.ResourceData)
+	d := ctx.Value(schema.ProvConfigDataKey).(*schema.ResourceData)
+	o := ctx.Value(schema.ProvOutputKey).(terraform.UIOutput)
+
+	vm := new(vix.VM)
+	vm.Provider = d.Get("product").(string)
+	vm.GuestUsername = conn.Get("user").(string)
+	vm.GuestPassword = conn.Get("password").(string)
+
+	if timeout := conn.Get("timeout").(string); timeout != "" {
+		var err error
+		vm.ToolsInitTimeout, err = time.ParseDuration(timeout)
+		if err != nil {
+			return fmt.Errorf("[ERROR] Invalid connection timeout %q: %s", timeout, err)
+		}
+	}
+
+	scripts, err := provisioner_scripts(d)
+	if err != nil {
+		return err
+	}
+
+	vmxFile := conn.Get("host").(string)
+	o.Output(fmt.Sprintf("Connecting to guest in %s through VMware Tools...", vmxFile))
+
+	session, err := vm.LoginInGuest(vmxFile)
+	if err != nil {
+		return err
+	}
+	defer session.Close()
+
+	filesCount := d.Get("file.#").(int)
+	for i := 0; i < filesCount; i++ {
+		prefix := fmt.Sprintf("file.%d.", i)
+		src := d.Get(prefix + "source").(string)
+		dest := d.Get(prefix + "destination").(string)
+
+		o.Output(fmt.Sprintf("Uploading %s to %s...", src, dest))
+		if err := session.CopyFileToGuest(src, dest); err != nil {
+			return fmt.Errorf("[ERROR] Unable to upload %s: %s", src, err)
+		}
+	}
+
+	interpreter := d.Get("interpreter").(string)
+	for i, script := range scripts {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+
+		o.Output(fmt.Sprintf("Running script %d of %d with %s...", i+1, len(scripts), interpreter))
+
+		// Output is only read back from guests with a POSIX shell
+		var pid uint64
+		var exitCode int
+		var output string
+		if strings.HasPrefix(interpreter, "/") {
+			pid, exitCode, output, err = session.RunScriptWithOutput(interpreter, script)
+		} else {
+			pid, exitCode, err = session.RunScript(interpreter, script)
+		}
+		if err != nil {
+			return fmt.Errorf("[ERROR] Unable to run script %d: %s", i+1, err)
+		}
+
+		if output = strings.TrimRight(output, "\n"); output != "" {
+			o.Output(output)
+		}
+
+		o.Output(fmt.Sprintf("Script %d (pid %d) exited with code %d", i+1, pid, exitCode))
+		if exitCode != 0 {
+			return fmt.Errorf("[ERROR] Script %d exited with code %d", i+1, exitCode)
+		}
+	}
+
+	return nil
+}
+
+// Collects the scripts to run in the guest. Inline commands make up a single
+// script, whereas scripts are read from the local filesystem.
+func provisioner_scripts(d *schema.ResourceData) ([]string, error) {
+	if inline := d.Get("inline").([]interface{}); len(inline) > 0 {
+		lines := make([]string, 0, len(inline)+1)
+		for _, line := range inline {
+			lines = append(lines, line.(string))
+		}
+		return []string{strings.Join(append(lines, ""), "\n")}, nil
+	}
+
+	var paths []string
+	if script := d.Get("script").(string); script != "" {
+		paths = append(paths, script)
+	}
+
+	for _, script := range d.Get("scripts").([]interface{}) {
+		paths = append(paths, script.(string))
+	}
+
+	scripts := make([]string, 0, len(paths))
+	for _, path := range paths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("[ERROR] Unable to read script %s: %s", path, err)
+		}
+		scripts = append(scripts, string(data))
+	}
+
+	return scripts, nil
+}
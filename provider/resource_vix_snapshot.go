@@ -0,0 +1,138 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+package provider
+
+import (
+	"log"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hooklift/terraform-provider-vix/provider/vix"
+)
+
+func resourceVIXSnapshot() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceVIXSnapshotCreate,
+		Read:   resourceVIXSnapshotRead,
+		Update: resourceVIXSnapshotUpdate,
+		Delete: resourceVIXSnapshotDelete,
+
+		Schema: map[string]*schema.Schema{
+			"vm_id": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"name": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			// VIX is unable to change descriptions of existing snapshots
+			"description": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
+			"include_memory": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+				ForceNew: true,
+			},
+			// Reverts the VM to the snapshot, instead of taking it again, if it
+			// already exists.
+			"revert_on_create": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+			// Keeps the snapshot on destroy. Along with revert_on_create,
+			// tainting the resource resets the VM to the snapshot.
+			"keep_on_destroy": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+		},
+	}
+}
+
+func newSnapshotVM(meta interface{}) *vix.VM {
+	config := meta.(*Config)
+
+	vm := new(vix.VM)
+	vm.Provider = config.Product
+	vm.VerifySSL = config.VerifySSL
+
+	return vm
+}
+
+func resourceVIXSnapshotCreate(d *schema.ResourceData, meta interface{}) error {
+	vm := newSnapshotVM(meta)
+	vmxFile := d.Get("vm_id").(string)
+	name := d.Get("name").(string)
+
+	var existing *vix.Snapshot
+	if d.Get("revert_on_create").(bool) {
+		var err error
+		if existing, err = vm.ReadSnapshot(vmxFile, name); err != nil {
+			return err
+		}
+	}
+
+	if existing != nil {
+		if err := vm.RevertToSnapshot(vmxFile, name); err != nil {
+			return err
+		}
+	} else {
+		err := vm.CreateSnapshot(vmxFile, &vix.Snapshot{
+			Name:          name,
+			Description:   d.Get("description").(string),
+			IncludeMemory: d.Get("include_memory").(bool),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	d.SetId(vmxFile + "#" + name)
+
+	return resourceVIXSnapshotRead(d, meta)
+}
+
+func resourceVIXSnapshotRead(d *schema.ResourceData, meta interface{}) error {
+	vm := newSnapshotVM(meta)
+	vmxFile := d.Get("vm_id").(string)
+	name := d.Get("name").(string)
+
+	snapshot, err := vm.ReadSnapshot(vmxFile, name)
+	if err != nil {
+		return err
+	}
+
+	// This is to let TF know the snapshot was deleted outside of it
+	if snapshot == nil {
+		log.Printf("[WARN] Snapshot %q of %s is gone", name, vmxFile)
+		d.SetId("")
+		return nil
+	}
+
+	// Descriptions are not refreshed as VIX does not reliably return them
+	// back, which would force snapshots to be taken over and over again.
+	return nil
+}
+
+func resourceVIXSnapshotUpdate(d *schema.ResourceData, meta interface{}) error {
+	return resourceVIXSnapshotRead(d, meta)
+}
+
+func resourceVIXSnapshotDelete(d *schema.ResourceData, meta interface{}) error {
+	if d.Get("keep_on_destroy").(bool) {
+		log.Printf("[INFO] Keeping snapshot %q as keep_on_destroy is set", d.Get("name"))
+		return nil
+	}
+
+	vm := newSnapshotVM(meta)
+	return vm.RemoveSnapshot(d.Get("vm_id").(string), d.Get("name").(string))
+}
@@ -0,0 +1,105 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+package provider
+
+import (
+	"log"
+	"os"
+	"time"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hooklift/terraform-provider-vix/provider/vix"
+)
+
+func resourceVIXTemplate() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceVIXTemplateCreate,
+		Read:   resourceVIXTemplateRead,
+		Delete: resourceVIXTemplateDelete,
+
+		Schema: map[string]*schema.Schema{
+			// Templates are named after their VMX file in the gold cache
+			"name": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"vm_id": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			// Requires guest credentials, the VM is powered on if it is not
+			// running already
+			"zero_free_space": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+				ForceNew: true,
+			},
+			"guest_username": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
+			"guest_password": &schema.Schema{
+				Type:      schema.TypeString,
+				Optional:  true,
+				ForceNew:  true,
+				Sensitive: true,
+			},
+			"tools_init_timeout": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "60s",
+				ForceNew:     true,
+				ValidateFunc: validateDuration,
+			},
+			// Arbitrary values that create the template again when they
+			// change, as changes to the VM itself are not tracked.
+			"triggers": &schema.Schema{
+				Type:     schema.TypeMap,
+				Optional: true,
+				ForceNew: true,
+			},
+		},
+	}
+}
+
+func resourceVIXTemplateCreate(d *schema.ResourceData, meta interface{}) error {
+	vm := newSnapshotVM(meta)
+	vm.GuestUsername = d.Get("guest_username").(string)
+	vm.GuestPassword = d.Get("guest_password").(string)
+
+	var err error
+	vm.ToolsInitTimeout, err = time.ParseDuration(d.Get("tools_init_timeout").(string))
+	if err != nil {
+		return err
+	}
+
+	tmplVMX, err := vm.CreateTemplate(d.Get("vm_id").(string), &vix.Template{
+		Name:          d.Get("name").(string),
+		ZeroFreeSpace: d.Get("zero_free_space").(bool),
+	})
+	if err != nil {
+		return err
+	}
+
+	d.SetId(tmplVMX)
+
+	return resourceVIXTemplateRead(d, meta)
+}
+
+func resourceVIXTemplateRead(d *schema.ResourceData, meta interface{}) error {
+	if _, err := os.Stat(d.Id()); os.IsNotExist(err) {
+		log.Printf("[WARN] Template %s is gone", d.Id())
+		d.SetId("")
+	}
+
+	return nil
+}
+
+func resourceVIXTemplateDelete(d *schema.ResourceData, meta interface{}) error {
+	return vix.DestroyTemplate(d.Id())
+}
@@ -4,18 +4,23 @@
 package provider
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"log"
 	"net"
+	"sort"
 	"strconv"
 	"time"
 
+	"github.com/dustin/go-humanize"
 	govix "github.com/hooklift/govix"
 	"github.com/hooklift/govmx"
 	"github.com/hooklift/terraform-provider-vix/provider/vix"
 
 	multierror "github.com/hashicorp/go-multierror"
 	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/helper/validation"
 )
 
 func resourceVIXVM() *schema.Resource {
@@ -25,6 +30,8 @@ func resourceVIXVM() *schema.Resource {
 		Update: resourceVIXVMUpdate,
 		Delete: resourceVIXVMDelete,
 
+		CustomizeDiff: resourceVIXVMCustomizeDiff,
+
 		Schema: map[string]*schema.Schema{
 			"name": &schema.Schema{
 				Type:     schema.TypeString,
@@ -43,22 +50,80 @@ func resourceVIXVM() *schema.Resource {
 				Default:  "2",
 			},
 
-			"memory": &schema.Schema{
-				Type:     schema.TypeString,
+			// Licensing sensitive software may need a fixed socket count,
+			// cpus has to be a multiple of it
+			"cores_per_socket": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Computed:     true,
+				ValidateFunc: validation.IntAtLeast(1),
+			},
+
+			"nested_virtualization": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Computed: true,
+			},
+
+			"performance_counters": &schema.Schema{
+				Type:     schema.TypeBool,
 				Optional: true,
-				Default:  "512mib",
+				Computed: true,
+			},
+
+			"page_sharing": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Computed: true,
+			},
+
+			"share_scan_total": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Computed:     true,
+				ValidateFunc: validation.IntAtLeast(0),
+			},
+
+			// Memory the balloon driver can reclaim, 0 disables ballooning
+			"balloon_limit": &schema.Schema{
+				Type:             schema.TypeString,
+				Optional:         true,
+				Computed:         true,
+				ValidateFunc:     validateMemoryLimit,
+				StateFunc:        normalizeMemoryLimit,
+				DiffSuppressFunc: suppressMemoryLimitDiff,
 			},
 
+			"memory": &schema.Schema{
+				Type:             schema.TypeString,
+				Optional:         true,
+				Default:          "512mib",
+				ValidateFunc:     validateMemory,
+				StateFunc:        normalizeMemory,
+				DiffSuppressFunc: suppressMemoryDiff,
+			},
+
+			// Upgrades to the latest virtual hardware supported by the host
 			"upgrade_vhardware": &schema.Schema{
 				Type:     schema.TypeBool,
 				Optional: true,
 				Default:  false,
 			},
 
+			// Pins the virtual hardware version, it can not be downgraded
+			"hardware_version": &schema.Schema{
+				Type:          schema.TypeInt,
+				Optional:      true,
+				Computed:      true,
+				ValidateFunc:  validation.IntAtLeast(4),
+				ConflictsWith: []string{"upgrade_vhardware"},
+			},
+
 			"tools_init_timeout": &schema.Schema{
-				Type:     schema.TypeString,
-				Optional: true,
-				Default:  "15s",
+				Type:         schema.TypeString,
+				Optional:     true,
+				Default:      "15s",
+				ValidateFunc: validateDuration,
 			},
 
 			"sharedfolders": &schema.Schema{
@@ -72,16 +137,318 @@ func resourceVIXVM() *schema.Resource {
 				Default:  false,
 			},
 
+			// VNC server of the console, for headless VMs
+			"vnc": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						// A free port is assigned if not set, ports of other
+						// managed VMs are skipped
+						"port": &schema.Schema{
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Computed:     true,
+							ValidateFunc: validation.IntBetween(1, 65535),
+						},
+						// Generated if not set
+						"password": &schema.Schema{
+							Type:      schema.TypeString,
+							Optional:  true,
+							Computed:  true,
+							Sensitive: true,
+						},
+						"ip": &schema.Schema{
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validation.SingleIP(),
+						},
+						"keymap": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"endpoint": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+					},
+				},
+			},
+
+			"safe_update": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Default:  false,
+			},
+
+			"safe_update_retain": &schema.Schema{
+				Type:         schema.TypeInt,
+				Optional:     true,
+				Default:      0,
+				ValidateFunc: validation.IntAtLeast(0),
+			},
+
+			"guest_username": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+
+			"guest_password": &schema.Schema{
+				Type:      schema.TypeString,
+				Optional:  true,
+				Sensitive: true,
+			},
+
 			"ip_address": &schema.Schema{
 				Type:     schema.TypeString,
 				Optional: true,
 				Computed: true,
 			},
 
-			"image": &schema.Schema{
+			"wait_for_ip": &schema.Schema{
 				Type:     schema.TypeList,
-				Required: true,
+				Optional: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"timeout": &schema.Schema{
+							Type:         schema.TypeString,
+							Optional:     true,
+							Default:      "5m",
+							ValidateFunc: validateDuration,
+						},
+						"network_adapter_index": &schema.Schema{
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Default:      0,
+							ValidateFunc: validation.IntAtLeast(0),
+						},
+						"exclude_cidrs": &schema.Schema{
+							Type:     schema.TypeList,
+							Optional: true,
+							Elem: &schema.Schema{
+								Type:         schema.TypeString,
+								ValidateFunc: validateCIDR,
+							},
+						},
+						"ipv6": &schema.Schema{
+							Type:     schema.TypeBool,
+							Optional: true,
+							Default:  false,
+						},
+					},
+				},
+			},
+
+			"guestinfo": &schema.Schema{
+				Type:             schema.TypeMap,
+				Optional:         true,
+				Elem:             &schema.Schema{Type: schema.TypeString},
+				ValidateFunc:     validateGuestInfo,
+				DiffSuppressFunc: suppressGuestInfoDiff,
+			},
+
+			"ignition_config": &schema.Schema{
+				Type:      schema.TypeString,
+				Optional:  true,
+				StateFunc: hashGuestInfo,
+			},
+
+			"cloudinit_userdata": &schema.Schema{
+				Type:      schema.TypeString,
+				Optional:  true,
+				StateFunc: hashGuestInfo,
+			},
+
+			"cloudinit_metadata": &schema.Schema{
+				Type:      schema.TypeString,
+				Optional:  true,
+				StateFunc: hashGuestInfo,
+			},
+
+			"guest_file": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"source": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"content": &schema.Schema{
+							Type:      schema.TypeString,
+							Optional:  true,
+							Sensitive: true,
+						},
+						"destination": &schema.Schema{
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"mode": &schema.Schema{
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validateFileMode,
+						},
+						"checksum": &schema.Schema{
+							Type:     schema.TypeString,
+							Computed: true,
+						},
+					},
+				},
+			},
+
+			// Changes whenever guest files change, even if only the content of
+			// their source did, so they get uploaded again.
+			"guest_files_checksum": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+
+			"guest_exec": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"username": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"password": &schema.Schema{
+							Type:      schema.TypeString,
+							Optional:  true,
+							Sensitive: true,
+						},
+						"interpreter": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+							Default:  "/bin/sh",
+						},
+						"script": &schema.Schema{
+							Type:     schema.TypeString,
+							Required: true,
+						},
+						"when": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+							Default:  vix.GuestExecOnCreate,
+							ValidateFunc: validation.StringInSlice([]string{
+								vix.GuestExecOnCreate, vix.GuestExecOnUpdate,
+							}, false),
+						},
+						"pid": &schema.Schema{
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"exit_code": &schema.Schema{
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+					},
+				},
+			},
+
+			"cloud_init": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				MaxItems: 1,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"user_data": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"meta_data": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+						"network_config": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+						},
+					},
+				},
+			},
+
+			// Existing virtual machine to clone instead of downloading an image
+			"source_vmx": &schema.Schema{
+				Type:          schema.TypeString,
+				Optional:      true,
+				ForceNew:      true,
+				ConflictsWith: []string{"image", "template_id"},
+			},
+
+			"source_snapshot": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				ForceNew: true,
+			},
+
+			"clone_type": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  "full",
 				ForceNew: true,
+				ValidateFunc: validation.StringInSlice([]string{
+					"full", "linked",
+				}, false),
+			},
+
+			// ID of a vix_template, VMs are always linked clones of templates
+			"template_id": &schema.Schema{
+				Type:          schema.TypeString,
+				Optional:      true,
+				ForceNew:      true,
+				ConflictsWith: []string{"image", "source_vmx", "source_snapshot"},
+			},
+
+			// Creates the VM from scratch when there is neither an image nor a
+			// source VM to clone, along with disk_size. Also used to build the
+			// VM around qcow2 and raw images, it is applied to existing VMs
+			// otherwise.
+			"guest_os": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+			},
+
+			// Grows the boot disk, disks can not shrink
+			"disk_size": &schema.Schema{
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validateSize,
+			},
+
+			"boot_iso": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+
+			"floppy_image": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+			},
+
+			"firmware": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				Computed: true,
+				ValidateFunc: validation.StringInSlice([]string{
+					"bios", "efi",
+				}, false),
+			},
+
+			"secure_boot": &schema.Schema{
+				Type:     schema.TypeBool,
+				Optional: true,
+				Computed: true,
+			},
+
+			"image": &schema.Schema{
+				Type:          schema.TypeList,
+				Optional:      true,
+				ForceNew:      true,
+				ConflictsWith: []string{"source_vmx", "template_id"},
 				Elem: &schema.Resource{
 					Schema: map[string]*schema.Schema{
 						"url": &schema.Schema{
@@ -95,11 +462,77 @@ func resourceVIXVM() *schema.Resource {
 						"checksum_type": &schema.Schema{
 							Type:     schema.TypeString,
 							Required: true,
+							ValidateFunc: validation.StringInSlice([]string{
+								"md5", "sha1", "sha256", "sha512",
+							}, false),
 						},
 						"password": &schema.Schema{
 							Type:     schema.TypeString,
 							Optional: true,
 						},
+						// Plain disk images are converted to VMDK, along with
+						// guest_os to build the virtual machine around them
+						"format": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+							ValidateFunc: validation.StringInSlice([]string{
+								vix.ImageFormatQCOW2, vix.ImageFormatRaw,
+							}, false),
+						},
+					},
+				},
+			},
+
+			"disk": &schema.Schema{
+				Type:     schema.TypeList,
+				Optional: true,
+				Elem: &schema.Resource{
+					Schema: map[string]*schema.Schema{
+						"bus": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+							Default:  "scsi",
+							ValidateFunc: validation.StringInSlice([]string{
+								"ide", "scsi", "sata", "nvme",
+							}, false),
+						},
+						"controller": &schema.Schema{
+							Type:         schema.TypeInt,
+							Optional:     true,
+							Default:      0,
+							ValidateFunc: validation.IntBetween(0, 3),
+						},
+						"unit": &schema.Schema{
+							Type:         schema.TypeInt,
+							Required:     true,
+							ValidateFunc: validation.IntBetween(0, 29),
+						},
+						"size": &schema.Schema{
+							Type:         schema.TypeString,
+							Optional:     true,
+							ValidateFunc: validateSize,
+						},
+						"mode": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+							Default:  vix.DiskModePersistent,
+							ValidateFunc: validation.StringInSlice([]string{
+								vix.DiskModePersistent,
+								vix.DiskModeNonPersistent,
+								vix.DiskModeIndependentPersistent,
+								vix.DiskModeIndependentNonPersistent,
+							}, false),
+						},
+						"path": &schema.Schema{
+							Type:     schema.TypeString,
+							Optional: true,
+							Computed: true,
+						},
+						"keep_file": &schema.Schema{
+							Type:     schema.TypeBool,
+							Optional: true,
+							Default:  false,
+						},
 					},
 				},
 			},
@@ -112,6 +545,9 @@ func resourceVIXVM() *schema.Resource {
 						"bus_type": &schema.Schema{
 							Type:     schema.TypeString,
 							Optional: true,
+							ValidateFunc: validation.StringInSlice([]string{
+								"ide", "scsi", "sata",
+							}, false),
 						},
 						"image": &schema.Schema{
 							Type:     schema.TypeString,
@@ -129,14 +565,41 @@ func resourceVIXVM() *schema.Resource {
 						"type": &schema.Schema{
 							Type:     schema.TypeString,
 							Required: true,
+							ValidateFunc: validation.StringInSlice([]string{
+								"bridged", "nat", "hostonly", "custom",
+							}, false),
 						},
 						"mac_address": &schema.Schema{
+							Type:         schema.TypeString,
+							Optional:     true,
+							Computed:     true,
+							ValidateFunc: validateStaticMAC,
+						},
+						"mac_address_type": &schema.Schema{
 							Type:     schema.TypeString,
 							Optional: true,
+							Default:  "generated",
+							ValidateFunc: validation.StringInSlice([]string{
+								"static", "auto_static", "generated", "vpx",
+							}, false),
 						},
-						"mac_address_type": &schema.Schema{
+						"generated_mac_address": &schema.Schema{
 							Type:     schema.TypeString,
+							Computed: true,
+						},
+						"pci_slot_number": &schema.Schema{
+							Type:     schema.TypeInt,
+							Computed: true,
+						},
+						"start_connected": &schema.Schema{
+							Type:     schema.TypeBool,
 							Optional: true,
+							Default:  true,
+						},
+						"wake_on_lan": &schema.Schema{
+							Type:     schema.TypeBool,
+							Optional: true,
+							Default:  false,
 						},
 						"vswitch": &schema.Schema{
 							Type:     schema.TypeString,
@@ -146,6 +609,9 @@ func resourceVIXVM() *schema.Resource {
 							Type:     schema.TypeString,
 							Optional: true,
 							Default:  "e1000",
+							ValidateFunc: validation.StringInSlice([]string{
+								"vlance", "e1000", "vmxnet3",
+							}, false),
 						},
 					},
 				},
@@ -167,6 +633,7 @@ func resourceVIXVM() *schema.Resource {
 						"guest_path": &schema.Schema{
 							Type:     schema.TypeString,
 							Optional: true,
+							Computed: true,
 						},
 						"host_path": &schema.Schema{
 							Type:     schema.TypeString,
@@ -183,6 +650,206 @@ func resourceVIXVM() *schema.Resource {
 	}
 }
 
+// Catches configuration errors at plan time that would otherwise only surface
+// once VMware is asked to apply them.
+func resourceVIXVMCustomizeDiff(d *schema.ResourceDiff, meta interface{}) error {
+	var errs []error
+
+	if d.Get("image.#").(int) == 0 && d.NewValueKnown("source_vmx") &&
+		d.Get("source_vmx").(string) == "" && d.NewValueKnown("template_id") &&
+		d.Get("template_id").(string) == "" {
+		if d.Get("guest_os").(string) == "" {
+			errs = append(errs, fmt.Errorf("one of image, source_vmx, template_id or guest_os is required"))
+		} else if d.Get("disk_size").(string) == "" {
+			errs = append(errs, fmt.Errorf("disk_size is required to create VMs from scratch"))
+		}
+	}
+
+	if format := d.Get("image.0.format").(string); format != "" && d.Get("guest_os").(string) == "" {
+		errs = append(errs, fmt.Errorf("guest_os is required to import %s images", format))
+	}
+
+	if d.Get("source_snapshot").(string) != "" && d.NewValueKnown("source_vmx") &&
+		d.Get("source_vmx").(string) == "" {
+		errs = append(errs, fmt.Errorf("source_snapshot requires source_vmx"))
+	}
+
+	adaptersCount := d.Get("network_adapter.#").(int)
+	for i := 0; i < adaptersCount; i++ {
+		prefix := fmt.Sprintf("network_adapter.%d.", i)
+
+		macType := d.Get(prefix + "mac_address_type").(string)
+		if d.NewValueKnown(prefix + "mac_address") {
+			mac := d.Get(prefix + "mac_address").(string)
+			if macType == "static" && mac == "" {
+				errs = append(errs, fmt.Errorf("%smac_address is required when mac_address_type is static", prefix))
+			}
+		}
+
+		if d.Get(prefix+"type").(string) != "custom" {
+			continue
+		}
+
+		// The vswitch may come from a vix_vswitch resource not created yet
+		if !d.NewValueKnown(prefix + "vswitch") {
+			continue
+		}
+
+		vswitch := d.Get(prefix + "vswitch").(string)
+		if vswitch == "" {
+			errs = append(errs, fmt.Errorf("%svswitch is required when the network adapter type is custom", prefix))
+			continue
+		}
+
+		if _, err := vix.VSwitchName(vswitch); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	for i := 0; i < d.Get("shared_folder.#").(int); i++ {
+		prefix := fmt.Sprintf("shared_folder.%d.", i)
+		if d.Get(prefix+"enable").(bool) && d.NewValueKnown(prefix+"host_path") &&
+			d.Get(prefix+"host_path").(string) == "" {
+			errs = append(errs, fmt.Errorf("%shost_path is required when the shared folder is enabled", prefix))
+		}
+	}
+
+	if cores := d.Get("cores_per_socket").(int); cores > 0 && d.NewValueKnown("cpus") &&
+		d.Get("cpus").(int)%cores != 0 {
+		errs = append(errs, fmt.Errorf("cpus has to be a multiple of cores_per_socket"))
+	}
+
+	if d.Get("secure_boot").(bool) && d.Get("firmware").(string) != "efi" {
+		errs = append(errs, fmt.Errorf("secure_boot requires firmware to be efi"))
+	}
+
+	// Devices and features the virtual hardware has to support. The version
+	// in state is checked as well, unless the hardware is about to be upgraded.
+	if hwVersion := d.Get("hardware_version").(int); hwVersion > 0 && d.NewValueKnown("hardware_version") &&
+		!d.Get("upgrade_vhardware").(bool) {
+		requireHardware := func(feature string, version int) {
+			if hwVersion < version {
+				errs = append(errs, fmt.Errorf("%s requires hardware_version %d or later, it is %d", feature, version, hwVersion))
+			}
+		}
+
+		for i := 0; i < adaptersCount; i++ {
+			prefix := fmt.Sprintf("network_adapter.%d.", i)
+			if d.Get(prefix+"driver").(string) == "vmxnet3" {
+				requireHardware(prefix+"driver vmxnet3", 7)
+			}
+		}
+
+		for i := 0; i < d.Get("disk.#").(int); i++ {
+			prefix := fmt.Sprintf("disk.%d.", i)
+			switch d.Get(prefix + "bus").(string) {
+			case "sata":
+				requireHardware(prefix+"bus sata", 10)
+			case "nvme":
+				requireHardware(prefix+"bus nvme", 13)
+			}
+		}
+
+		if d.Get("secure_boot").(bool) {
+			requireHardware("secure_boot", 14)
+		}
+	}
+
+	if o, n := d.GetChange("disk_size"); o.(string) != "" && n.(string) != "" {
+		oldSize, oerr := humanize.ParseBytes(o.(string))
+		newSize, nerr := humanize.ParseBytes(n.(string))
+		if oerr == nil && nerr == nil && newSize < oldSize {
+			errs = append(errs, fmt.Errorf("disk_size can not shrink from %s to %s", o, n))
+		}
+	}
+
+	disksCount := d.Get("disk.#").(int)
+	slots := make(map[string]bool, disksCount)
+	for i := 0; i < disksCount; i++ {
+		prefix := fmt.Sprintf("disk.%d.", i)
+		disk := &vix.Disk{
+			Bus:        d.Get(prefix + "bus").(string),
+			Controller: d.Get(prefix + "controller").(int),
+			Unit:       d.Get(prefix + "unit").(int),
+		}
+
+		if err := disk.Validate(); err != nil {
+			errs = append(errs, fmt.Errorf("%s: %s", prefix, err))
+			continue
+		}
+
+		if slots[disk.ID()] {
+			errs = append(errs, fmt.Errorf("%s: %s is used by another disk", prefix, disk.ID()))
+		}
+		slots[disk.ID()] = true
+
+		// Paths of created disks are only known once they are attached
+		if !d.NewValueKnown(prefix+"path") || !d.NewValueKnown(prefix+"size") {
+			continue
+		}
+
+		if d.Get(prefix+"path").(string) == "" && d.Get(prefix+"size").(string) == "" {
+			errs = append(errs, fmt.Errorf("%s: either size or path is required", prefix))
+		}
+	}
+
+	filesCount := d.Get("guest_file.#").(int)
+	files := make([]*vix.GuestFile, 0, filesCount)
+	filesKnown := true
+	for i := 0; i < filesCount; i++ {
+		prefix := fmt.Sprintf("guest_file.%d.", i)
+
+		if !d.NewValueKnown(prefix+"source") || !d.NewValueKnown(prefix+"content") {
+			filesKnown = false
+			continue
+		}
+
+		source := d.Get(prefix + "source").(string)
+		content := d.Get(prefix + "content").(string)
+		if (source == "") == (content == "") {
+			errs = append(errs, fmt.Errorf("%s: exactly one of source or content is required", prefix))
+			continue
+		}
+
+		files = append(files, &vix.GuestFile{
+			Source:      source,
+			Content:     content,
+			Destination: d.Get(prefix + "destination").(string),
+			Mode:        d.Get(prefix + "mode").(string),
+		})
+	}
+
+	if filesKnown && len(errs) == 0 {
+		checksum, err := vix.GuestFilesChecksum(files, true)
+		if err != nil {
+			log.Printf("[WARN] Unable to find out whether guest files changed: %s", err)
+		} else if checksum != d.Get("guest_files_checksum").(string) {
+			if err = d.SetNew("guest_files_checksum", checksum); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	guestinfo := d.Get("guestinfo").(map[string]interface{})
+	for attr, names := range guestInfoAttrs {
+		if d.Get(attr).(string) == "" {
+			continue
+		}
+
+		for _, name := range names {
+			if _, ok := guestinfo[name]; ok {
+				errs = append(errs, fmt.Errorf("guestinfo.%s conflicts with %s", name, attr))
+			}
+		}
+	}
+
+	if len(errs) > 0 {
+		return &multierror.Error{Errors: errs}
+	}
+
+	return nil
+}
+
 func net_tf_to_vix(d *schema.ResourceData, vm *vix.VM) error {
 	tf_to_vix_virtual_device := func(attr string) (govix.VNetDevice, error) {
 		switch attr {
@@ -212,14 +879,11 @@ func net_tf_to_vix(d *schema.ResourceData, vm *vix.VM) error {
 		}
 	}
 
-	// tf_to_vix_vswitch := func(attr string) (vix.VSwitch, error) {
-	// 	return vix.VSwitch{}, nil
-	// }
-
 	var err error
 	var errs []error
 	adaptersCount := d.Get("network_adapter.#").(int)
 	vm.VNetworkAdapters = make([]*govix.NetworkAdapter, 0, adaptersCount)
+	vm.VSwitches = make([]string, 0, adaptersCount)
 
 	for i := 0; i < adaptersCount; i++ {
 		prefix := fmt.Sprintf("network_adapter.%d.", i)
@@ -229,28 +893,39 @@ func net_tf_to_vix(d *schema.ResourceData, vm *vix.VM) error {
 			adapter.Vdevice, err = tf_to_vix_virtual_device(attr)
 		}
 
+		if attr, ok := d.Get(prefix + "mac_address_type").(string); ok && attr != "" {
+			adapter.MacAddrType = govix.MacAddressType(attr)
+		}
+
 		if attr, ok := d.Get(prefix + "mac_address").(string); ok && attr != "" {
 			// Only set a MAC address if it is declared as static
 			// otherwise leave Govix to assign or continue using the generated
-			// one.
-			if addrtype, ok := d.Get(prefix + "mac_address_type").(string); ok && addrtype == "static" {
-				adapter.MacAddress, err = net.ParseMAC(attr)
+			// one. Auto static addresses are kept once they are in the state.
+			if adapter.MacAddrType == govix.NETWORK_MACADDRESSTYPE_STATIC ||
+				adapter.MacAddrType == vix.MACAddressTypeAutoStatic {
+				adapter.MacAddress, err = vix.ParseStaticMAC(attr)
 			}
 		}
 
+		adapter.StartConnected = d.Get(prefix + "start_connected").(bool)
+		adapter.WakeOnPcktRcv = d.Get(prefix + "wake_on_lan").(bool)
+
 		if attr, ok := d.Get(prefix + "type").(string); ok && attr != "" {
 			adapter.ConnType, err = tf_to_vix_network_type(attr)
 		}
 
-		// if attr, ok := adapter["vswitch"].(string); ok && attr != "" {
-		// 	vnic.VSwitch, err = tf_to_vix_vswitch(attr)
-		// }
+		var vswitch string
+		if adapter.ConnType == govix.NETWORK_CUSTOM {
+			vswitch, err = vix.VSwitchName(d.Get(prefix + "vswitch").(string))
+		}
+
 		if err != nil {
 			errs = append(errs, err)
 		}
 
 		log.Printf("[DEBUG] Network adapter: %+v\n", adapter)
 		vm.VNetworkAdapters = append(vm.VNetworkAdapters, adapter)
+		vm.VSwitches = append(vm.VSwitches, vswitch)
 	}
 
 	if len(errs) > 0 {
@@ -281,6 +956,394 @@ func cdrom_tf_to_vix(d *schema.ResourceData, vm *vix.VM) error {
 	return nil
 }
 
+func disk_from_tf(attrs map[string]interface{}) *vix.Disk {
+	return &vix.Disk{
+		Bus:        attrs["bus"].(string),
+		Controller: attrs["controller"].(int),
+		Unit:       attrs["unit"].(int),
+		Size:       attrs["size"].(string),
+		Mode:       attrs["mode"].(string),
+		Path:       attrs["path"].(string),
+		KeepFile:   attrs["keep_file"].(bool),
+	}
+}
+
+// Disks removed from the configuration are detached, and their files removed
+// unless keep_file was set.
+func disk_tf_to_vix(d *schema.ResourceData, vm *vix.VM) error {
+	o, n := d.GetChange("disk")
+
+	wanted := make(map[string]bool)
+	vm.Disks = nil
+	for _, attrs := range n.([]interface{}) {
+		disk := disk_from_tf(attrs.(map[string]interface{}))
+		wanted[disk.ID()] = true
+		vm.Disks = append(vm.Disks, disk)
+	}
+
+	vm.DetachedDisks = nil
+	for _, attrs := range o.([]interface{}) {
+		disk := disk_from_tf(attrs.(map[string]interface{}))
+		if !wanted[disk.ID()] {
+			vm.DetachedDisks = append(vm.DetachedDisks, disk)
+		}
+	}
+
+	return nil
+}
+
+// Shares removed from the configuration are removed from the guest too, even
+// if no shares are left.
+func shares_tf_to_vix(d *schema.ResourceData, vm *vix.VM) error {
+	sharesCount := d.Get("shared_folder.#").(int)
+	vm.Shares = make([]*vix.SharedFolder, 0, sharesCount)
+
+	wanted := make(map[string]bool, sharesCount)
+	for i := 0; i < sharesCount; i++ {
+		prefix := fmt.Sprintf("shared_folder.%d.", i)
+
+		share := &vix.SharedFolder{
+			Name:      d.Get(prefix + "name").(string),
+			Enable:    d.Get(prefix + "enable").(bool),
+			HostPath:  d.Get(prefix + "host_path").(string),
+			ReadOnly:  d.Get(prefix + "readonly").(bool),
+			GuestPath: d.Get(prefix + "guest_path").(string),
+		}
+		wanted[share.Name] = true
+		vm.Shares = append(vm.Shares, share)
+	}
+
+	vm.RemovedShares = nil
+	o, _ := d.GetChange("shared_folder")
+	for _, attrs := range o.([]interface{}) {
+		name := attrs.(map[string]interface{})["name"].(string)
+		if !wanted[name] {
+			vm.RemovedShares = append(vm.RemovedShares, name)
+		}
+	}
+
+	return nil
+}
+
+func waitforip_tf_to_vix(d *schema.ResourceData, vm *vix.VM) error {
+	if d.Get("wait_for_ip.#").(int) == 0 {
+		vm.WaitForIP = nil
+		return nil
+	}
+
+	prefix := "wait_for_ip.0."
+	timeout, err := time.ParseDuration(d.Get(prefix + "timeout").(string))
+	if err != nil {
+		return err
+	}
+
+	vm.WaitForIP = &vix.WaitForIP{
+		Timeout:             timeout,
+		NetworkAdapterIndex: d.Get(prefix + "network_adapter_index").(int),
+		IPv6:                d.Get(prefix + "ipv6").(bool),
+	}
+
+	for _, attr := range d.Get(prefix + "exclude_cidrs").([]interface{}) {
+		_, cidr, err := net.ParseCIDR(attr.(string))
+		if err != nil {
+			return err
+		}
+		vm.WaitForIP.ExcludeCIDRs = append(vm.WaitForIP.ExcludeCIDRs, cidr)
+	}
+
+	return nil
+}
+
+func cloudinit_tf_to_vix(d *schema.ResourceData, vm *vix.VM) error {
+	if d.Get("cloud_init.#").(int) == 0 {
+		vm.CloudInit = nil
+		return nil
+	}
+
+	prefix := "cloud_init.0."
+	vm.CloudInit = &vix.CloudInit{
+		UserData:      d.Get(prefix + "user_data").(string),
+		MetaData:      d.Get(prefix + "meta_data").(string),
+		NetworkConfig: d.Get(prefix + "network_config").(string),
+	}
+
+	return nil
+}
+
+func guestfile_tf_to_vix(d *schema.ResourceData, vm *vix.VM) error {
+	filesCount := d.Get("guest_file.#").(int)
+	vm.GuestFiles = make([]*vix.GuestFile, 0, filesCount)
+
+	for i := 0; i < filesCount; i++ {
+		prefix := fmt.Sprintf("guest_file.%d.", i)
+
+		vm.GuestFiles = append(vm.GuestFiles, &vix.GuestFile{
+			Source:      d.Get(prefix + "source").(string),
+			Content:     d.Get(prefix + "content").(string),
+			Destination: d.Get(prefix + "destination").(string),
+			Mode:        d.Get(prefix + "mode").(string),
+			Checksum:    d.Get(prefix + "checksum").(string),
+		})
+	}
+
+	return nil
+}
+
+// Records checksums of uploaded files, so they only get uploaded again once
+// they change. Nested attributes can not be set one by one, the whole list is
+// set at once.
+func guestfile_vix_to_tf(vm *vix.VM, d *schema.ResourceData) error {
+	files := d.Get("guest_file").([]interface{})
+	for i, attrs := range files {
+		if i >= len(vm.GuestFiles) {
+			break
+		}
+
+		file := make(map[string]interface{})
+		for k, v := range attrs.(map[string]interface{}) {
+			file[k] = v
+		}
+		file["checksum"] = vm.GuestFiles[i].Checksum
+		files[i] = file
+	}
+
+	if err := d.Set("guest_file", files); err != nil {
+		return err
+	}
+
+	checksum, err := vix.GuestFilesChecksum(vm.GuestFiles, false)
+	if err != nil {
+		return err
+	}
+
+	return d.Set("guest_files_checksum", checksum)
+}
+
+func guestexec_tf_to_vix(d *schema.ResourceData, vm *vix.VM) error {
+	execCount := d.Get("guest_exec.#").(int)
+	vm.GuestExecs = make([]*vix.GuestExec, 0, execCount)
+
+	for i := 0; i < execCount; i++ {
+		prefix := fmt.Sprintf("guest_exec.%d.", i)
+
+		vm.GuestExecs = append(vm.GuestExecs, &vix.GuestExec{
+			Username:    d.Get(prefix + "username").(string),
+			Password:    d.Get(prefix + "password").(string),
+			Interpreter: d.Get(prefix + "interpreter").(string),
+			Script:      d.Get(prefix + "script").(string),
+			When:        d.Get(prefix + "when").(string),
+		})
+	}
+
+	return nil
+}
+
+// Records PIDs and exit codes of the scripts run by the last operation.
+// Nested attributes can not be set one by one, the whole list is set at once.
+func guestexec_vix_to_tf(vm *vix.VM, d *schema.ResourceData) error {
+	execs := d.Get("guest_exec").([]interface{})
+	for i, attrs := range execs {
+		if i >= len(vm.GuestExecs) || vm.GuestExecs[i].PID == 0 {
+			continue
+		}
+
+		exec := make(map[string]interface{})
+		for k, v := range attrs.(map[string]interface{}) {
+			exec[k] = v
+		}
+		exec["pid"] = int(vm.GuestExecs[i].PID)
+		exec["exit_code"] = vm.GuestExecs[i].ExitCode
+		execs[i] = exec
+	}
+
+	return d.Set("guest_exec", execs)
+}
+
+// Attributes exposing well known guestinfo variables
+var guestInfoAttrs = map[string][]string{
+	// Container Linux reads the former, Ignition v2 and later the latter
+	"ignition_config":    []string{"coreos.config.data", "ignition.config.data"},
+	"cloudinit_userdata": []string{"userdata"},
+	"cloudinit_metadata": []string{"metadata"},
+}
+
+// Stores guestinfo values as hashes, so secrets are not persisted in plain
+// text in the state file.
+func hashGuestInfo(v interface{}) string {
+	sum := sha256.Sum256([]byte(v.(string)))
+	return hex.EncodeToString(sum[:])
+}
+
+// guestinfo values in state are hashes, they are compared against hashed
+// configuration values instead.
+func suppressGuestInfoDiff(k, old, new string, d *schema.ResourceData) bool {
+	return old == hashGuestInfo(new)
+}
+
+// Memory sizes are kept in megabytes, so 1gib and 1024mib are the same value.
+// Sizes that can not be parsed are kept as is, validation reports them.
+func normalizeMemory(v interface{}) string {
+	size := v.(string)
+	memory, err := vix.ParseMemory(size)
+	if err != nil {
+		return size
+	}
+	return fmt.Sprintf("%dmib", memory)
+}
+
+func suppressMemoryDiff(k, old, new string, d *schema.ResourceData) bool {
+	return normalizeMemory(old) == normalizeMemory(new)
+}
+
+// Limits are kept in megabytes, like memory sizes, although they can be zero
+func normalizeMemoryLimit(v interface{}) string {
+	limit := v.(string)
+	bytes, err := humanize.ParseBytes(limit)
+	if err != nil {
+		return limit
+	}
+	return fmt.Sprintf("%dmib", bytes/1024/1024)
+}
+
+func suppressMemoryLimitDiff(k, old, new string, d *schema.ResourceData) bool {
+	return normalizeMemoryLimit(old) == normalizeMemoryLimit(new)
+}
+
+// Mapping VNC settings can not fail, ip and port are validated at plan time
+// and free ports and passwords are only assigned when applying them.
+func vnc_tf_to_vix(d *schema.ResourceData, vm *vix.VM) {
+	if d.Get("vnc.#").(int) == 0 {
+		vm.VNC = nil
+		return
+	}
+
+	vm.VNC = &vix.VNC{
+		Port:     uint(d.Get("vnc.0.port").(int)),
+		Password: d.Get("vnc.0.password").(string),
+		IP:       d.Get("vnc.0.ip").(string),
+		KeyMap:   d.Get("vnc.0.keymap").(string),
+	}
+}
+
+func vnc_vix_to_tf(vm *vix.VM, d *schema.ResourceData) error {
+	if vm.VNC == nil {
+		return d.Set("vnc", nil)
+	}
+
+	return d.Set("vnc", []map[string]interface{}{{
+		"port":     int(vm.VNC.Port),
+		"password": vm.VNC.Password,
+		"ip":       vm.VNC.IP,
+		"keymap":   vm.VNC.KeyMap,
+		"endpoint": vm.VNC.Endpoint(),
+	}})
+}
+
+// Unset tuning settings are carried over from the VMX file
+func tuning_tf_to_vix(d *schema.ResourceData, vm *vix.VM) error {
+	optionalBool := func(key string) *bool {
+		if v, ok := d.GetOkExists(key); ok {
+			b := v.(bool)
+			return &b
+		}
+		return nil
+	}
+
+	vm.Tuning = vix.Tuning{
+		CoresPerSocket:       d.Get("cores_per_socket").(int),
+		NestedVirtualization: optionalBool("nested_virtualization"),
+		PerformanceCounters:  optionalBool("performance_counters"),
+		PageSharing:          optionalBool("page_sharing"),
+		BalloonLimit:         d.Get("balloon_limit").(string),
+	}
+
+	if v, ok := d.GetOkExists("share_scan_total"); ok {
+		total := v.(int)
+		vm.Tuning.ShareScanTotal = &total
+	}
+
+	if limit := vm.Tuning.BalloonLimit; limit != "" {
+		if _, err := humanize.ParseBytes(limit); err != nil {
+			return fmt.Errorf("invalid balloon_limit %q: %s", limit, err)
+		}
+	}
+
+	return nil
+}
+
+// Only hashes of guestinfo values are known once applied, values that did not
+// change are left nil so they are carried over from the VMX file. Variables
+// set before but no longer wanted are flagged as stale.
+func guestinfo_tf_to_vix(d *schema.ResourceData, vm *vix.VM) error {
+	vm.GuestInfo = make(map[string]*string)
+
+	o, n := d.GetChange("guestinfo")
+	oldVars := o.(map[string]interface{})
+	for name, attr := range n.(map[string]interface{}) {
+		value := attr.(string)
+		if old, ok := oldVars[name]; ok && old.(string) == value {
+			vm.GuestInfo[name] = nil
+			continue
+		}
+		vm.GuestInfo[name] = &value
+	}
+
+	for attr, names := range guestInfoAttrs {
+		value := d.Get(attr).(string)
+		if value == "" {
+			continue
+		}
+
+		for _, name := range names {
+			if d.HasChange(attr) {
+				vm.GuestInfo[name] = &value
+			} else {
+				vm.GuestInfo[name] = nil
+			}
+		}
+	}
+
+	// Only variables Terraform set before are removed, others are left alone
+	stale := make(map[string]bool)
+	for name := range oldVars {
+		stale[name] = true
+	}
+	for attr, names := range guestInfoAttrs {
+		if old, _ := d.GetChange(attr); old.(string) != "" {
+			for _, name := range names {
+				stale[name] = true
+			}
+		}
+	}
+
+	for name := range stale {
+		if _, ok := vm.GuestInfo[name]; !ok {
+			vm.StaleGuestInfo = append(vm.StaleGuestInfo, name)
+		}
+	}
+	sort.Strings(vm.StaleGuestInfo)
+
+	return nil
+}
+
+// Replaces guestinfo values with their hashes once mapped to VIX.
+func guestinfo_hash_state(d *schema.ResourceData) error {
+	vars := d.Get("guestinfo").(map[string]interface{})
+	hashes := make(map[string]interface{}, len(vars))
+
+	o, _ := d.GetChange("guestinfo")
+	oldVars := o.(map[string]interface{})
+	for name, attr := range vars {
+		if old, ok := oldVars[name]; ok && old == attr {
+			hashes[name] = attr
+			continue
+		}
+		hashes[name] = hashGuestInfo(attr)
+	}
+
+	return d.Set("guestinfo", hashes)
+}
+
 // Maps Terraform attributes to provider's structs
 func tf_to_vix(d *schema.ResourceData, vm *vix.VM) error {
 	var err error
@@ -293,8 +1356,15 @@ func tf_to_vix(d *schema.ResourceData, vm *vix.VM) error {
 	vm.UpgradeVHardware = d.Get("upgrade_vhardware").(bool)
 	vm.LaunchGUI = d.Get("gui").(bool)
 	vm.SharedFolders = d.Get("sharedfolders").(bool)
+	vm.GuestUsername = d.Get("guest_username").(string)
+	vm.GuestPassword = d.Get("guest_password").(string)
+	vm.SafeUpdate = d.Get("safe_update").(bool)
+	vm.SafeUpdateRetain = d.Get("safe_update_retain").(int)
 
 	vm.ToolsInitTimeout, err = time.ParseDuration(d.Get("tools_init_timeout").(string))
+	if err != nil {
+		return fmt.Errorf("Error parsing tools_init_timeout: %s", err)
+	}
 
 	// Maps any defined networks to VIX provider's data types
 	err = net_tf_to_vix(d, vm)
@@ -302,6 +1372,30 @@ func tf_to_vix(d *schema.ResourceData, vm *vix.VM) error {
 		return fmt.Errorf("Error mapping TF network adapter resource to VIX data types: %s", err)
 	}
 
+	vm.SourceVMX = d.Get("source_vmx").(string)
+	vm.SourceSnapshot = d.Get("source_snapshot").(string)
+	vm.CloneType = govix.CLONETYPE_FULL
+	if d.Get("clone_type").(string) == "linked" {
+		vm.CloneType = govix.CLONETYPE_LINKED
+	}
+
+	if template := d.Get("template_id").(string); template != "" {
+		vm.SourceVMX = template
+		vm.SourceSnapshot = vix.TemplateSnapshot
+		vm.CloneType = govix.CLONETYPE_LINKED
+	}
+
+	vm.GuestOS = d.Get("guest_os").(string)
+	vm.DiskSize = d.Get("disk_size").(string)
+	vm.BootISO = d.Get("boot_iso").(string)
+	vm.FloppyImage = d.Get("floppy_image").(string)
+	vm.Firmware = d.Get("firmware").(string)
+	vm.SecureBoot = d.Get("secure_boot").(bool)
+	// The version in state is left alone so the hardware keeps being upgraded
+	if !vm.UpgradeVHardware {
+		vm.HardwareVersion = d.Get("hardware_version").(int)
+	}
+
 	if i := d.Get("image.#").(int); i > 0 {
 		prefix := "image.0."
 		vm.Image = vix.Image{
@@ -309,14 +1403,57 @@ func tf_to_vix(d *schema.ResourceData, vm *vix.VM) error {
 			Checksum:     d.Get(prefix + "checksum").(string),
 			ChecksumType: d.Get(prefix + "checksum_type").(string),
 			Password:     d.Get(prefix + "password").(string),
+			Format:       d.Get(prefix + "format").(string),
 		}
 	}
 
+	err = disk_tf_to_vix(d, vm)
+	if err != nil {
+		return fmt.Errorf("Error mapping TF disk resource to VIX data types: %s", err)
+	}
+
 	err = cdrom_tf_to_vix(d, vm)
 	if err != nil {
 		return fmt.Errorf("Error mapping TF cdrom resource to VIX data types: %s", err)
 	}
 
+	err = shares_tf_to_vix(d, vm)
+	if err != nil {
+		return fmt.Errorf("Error mapping TF shared folder resource to VIX data types: %s", err)
+	}
+
+	err = waitforip_tf_to_vix(d, vm)
+	if err != nil {
+		return fmt.Errorf("Error mapping TF wait_for_ip resource to VIX data types: %s", err)
+	}
+
+	err = cloudinit_tf_to_vix(d, vm)
+	if err != nil {
+		return fmt.Errorf("Error mapping TF cloud_init resource to VIX data types: %s", err)
+	}
+
+	err = guestfile_tf_to_vix(d, vm)
+	if err != nil {
+		return fmt.Errorf("Error mapping TF guest_file resource to VIX data types: %s", err)
+	}
+
+	err = guestexec_tf_to_vix(d, vm)
+	if err != nil {
+		return fmt.Errorf("Error mapping TF guest_exec resource to VIX data types: %s", err)
+	}
+
+	err = guestinfo_tf_to_vix(d, vm)
+	if err != nil {
+		return fmt.Errorf("Error mapping TF guestinfo resource to VIX data types: %s", err)
+	}
+
+	err = tuning_tf_to_vix(d, vm)
+	if err != nil {
+		return fmt.Errorf("Error mapping TF tuning attributes to VIX data types: %s", err)
+	}
+
+	vnc_tf_to_vix(d, vm)
+
 	return nil
 }
 
@@ -338,6 +1475,40 @@ func cdrom_vix_to_tf(vm *vix.VM, d *schema.ResourceData) error {
 	return nil
 }
 
+// Nested attributes can not be set one by one, the whole list is set at once.
+func disk_vix_to_tf(vm *vix.VM, d *schema.ResourceData) error {
+	disks := make([]map[string]interface{}, 0, len(vm.Disks))
+	for _, disk := range vm.Disks {
+		disks = append(disks, map[string]interface{}{
+			"bus":        disk.Bus,
+			"controller": disk.Controller,
+			"unit":       disk.Unit,
+			"size":       disk.Size,
+			"mode":       disk.Mode,
+			"path":       disk.Path,
+			"keep_file":  disk.KeepFile,
+		})
+	}
+
+	return d.Set("disk", disks)
+}
+
+// Nested attributes can not be set one by one, the whole list is set at once.
+func shares_vix_to_tf(vm *vix.VM, d *schema.ResourceData) error {
+	shares := make([]map[string]interface{}, 0, len(vm.Shares))
+	for _, share := range vm.Shares {
+		shares = append(shares, map[string]interface{}{
+			"name":       share.Name,
+			"enable":     share.Enable,
+			"host_path":  share.HostPath,
+			"readonly":   share.ReadOnly,
+			"guest_path": share.GuestPath,
+		})
+	}
+
+	return d.Set("shared_folder", shares)
+}
+
 func net_vix_to_tf(vm *vix.VM, d *schema.ResourceData) error {
 
 	vix_to_tf_network_type := func(netType govix.NetworkType) string {
@@ -355,15 +1526,11 @@ func net_vix_to_tf(vm *vix.VM, d *schema.ResourceData) error {
 		}
 	}
 
-	vix_to_tf_macaddress := func(adapter *govix.NetworkAdapter) string {
-		static := adapter.MacAddress.String()
-		generated := adapter.GeneratedMacAddress.String()
-
-		if static != "" {
-			return static
+	vix_to_tf_mactype := func(adapter *govix.NetworkAdapter) string {
+		if adapter.MacAddrType == "" {
+			return string(govix.NETWORK_MACADDRESSTYPE_GENERATED)
 		}
-
-		return generated
+		return string(adapter.MacAddrType)
 	}
 
 	vix_to_tf_vdevice := func(vdevice govix.VNetDevice) string {
@@ -379,25 +1546,38 @@ func net_vix_to_tf(vm *vix.VM, d *schema.ResourceData) error {
 		}
 	}
 
-	numvnics := len(vm.VNetworkAdapters)
-	if numvnics <= 0 {
-		return nil
-	}
-
-	prefix := "network_adapter"
-
-	d.Set(prefix+".#", strconv.Itoa(numvnics))
+	// Nested attributes can not be set one by one, the whole list is set at once
+	adapters := make([]map[string]interface{}, 0, len(vm.VNetworkAdapters))
 	for i, adapter := range vm.VNetworkAdapters {
-		attr := fmt.Sprintf("%s.%d.", prefix, i)
-		d.Set(attr+"type", vix_to_tf_network_type(adapter.ConnType))
-		d.Set(attr+"mac_address", vix_to_tf_macaddress(adapter))
-		if adapter.ConnType == govix.NETWORK_CUSTOM {
-			d.Set(attr+"vswitch", "TODO(c4milo)")
+		// Auto static addresses are attached as static ones
+		mactype := vix_to_tf_mactype(adapter)
+		configured := d.Get(fmt.Sprintf("network_adapter.%d.mac_address_type", i))
+		if configured.(string) == string(vix.MACAddressTypeAutoStatic) &&
+			adapter.MacAddrType == govix.NETWORK_MACADDRESSTYPE_STATIC {
+			mactype = string(vix.MACAddressTypeAutoStatic)
+		}
+
+		var vswitch string
+		if adapter.ConnType == govix.NETWORK_CUSTOM && i < len(vm.VSwitches) {
+			vswitch = vm.VSwitches[i]
 		}
-		d.Set(attr+"driver", vix_to_tf_vdevice(adapter.Vdevice))
+
+		slot, _ := strconv.Atoi(adapter.PciSlotNumber)
+
+		adapters = append(adapters, map[string]interface{}{
+			"type":                  vix_to_tf_network_type(adapter.ConnType),
+			"mac_address":           adapter.MacAddress.String(),
+			"mac_address_type":      mactype,
+			"generated_mac_address": adapter.GeneratedMacAddress.String(),
+			"pci_slot_number":       slot,
+			"start_connected":       adapter.StartConnected,
+			"wake_on_lan":           adapter.WakeOnPcktRcv,
+			"vswitch":               vswitch,
+			"driver":                vix_to_tf_vdevice(adapter.Vdevice),
+		})
 	}
 
-	return nil
+	return d.Set("network_adapter", adapters)
 }
 
 func resourceVIXVMCreate(d *schema.ResourceData, meta interface{}) error {
@@ -411,7 +1591,19 @@ func resourceVIXVMCreate(d *schema.ResourceData, meta interface{}) error {
 		return err
 	}
 
+	if err := guestinfo_hash_state(d); err != nil {
+		return err
+	}
+
+	// Files uploaded and scripts run are recorded even if creating failed
+	// afterwards
 	id, err := vm.Create()
+	if serr := guestfile_vix_to_tf(vm, d); err == nil {
+		err = serr
+	}
+	if serr := guestexec_vix_to_tf(vm, d); err == nil {
+		err = serr
+	}
 	if err != nil {
 		return err
 	}
@@ -436,9 +1628,23 @@ func resourceVIXVMUpdate(d *schema.ResourceData, meta interface{}) error {
 	vm.VerifySSL = config.VerifySSL
 
 	// Maps terraform.ResourceState attrbutes to vix.VM
-	tf_to_vix(d, vm)
+	if err := tf_to_vix(d, vm); err != nil {
+		return err
+	}
+
+	if err := guestinfo_hash_state(d); err != nil {
+		return err
+	}
 
+	// Files uploaded and scripts run are recorded even if updating failed
+	// afterwards
 	err := vm.Update(d.Id())
+	if serr := guestfile_vix_to_tf(vm, d); err == nil {
+		err = serr
+	}
+	if serr := guestexec_vix_to_tf(vm, d); err == nil {
+		err = serr
+	}
 	if err != nil {
 		return err
 	}
@@ -469,6 +1675,23 @@ func resourceVIXVMRead(d *schema.ResourceData, meta interface{}) error {
 	vm := new(vix.VM)
 	vm.Provider = config.Product
 	vm.VerifySSL = config.VerifySSL
+	vm.GuestUsername = d.Get("guest_username").(string)
+	vm.GuestPassword = d.Get("guest_password").(string)
+
+	// Shares are refreshed against the ones known by Terraform
+	if err := shares_tf_to_vix(d, vm); err != nil {
+		return err
+	}
+
+	// So are disks, the ones from the image are not managed
+	if err := disk_tf_to_vix(d, vm); err != nil {
+		return err
+	}
+
+	// Only usable addresses are refreshed if a wait strategy is configured
+	if err := waitforip_tf_to_vix(d, vm); err != nil {
+		return err
+	}
 
 	running, err := vm.Refresh(vmxFile)
 	if err != nil {
@@ -485,8 +1708,26 @@ func resourceVIXVMRead(d *schema.ResourceData, meta interface{}) error {
 	d.Set("name", vm.Name)
 	d.Set("description", vm.Description)
 	d.Set("cpus", vm.CPUs)
-	d.Set("memory", vm.Memory)
-	d.Set("ip_address", vm.IPAddress)
+	d.Set("memory", normalizeMemory(vm.Memory))
+	d.Set("guest_os", vm.GuestOS)
+	d.Set("hardware_version", vm.HardwareVersion)
+	d.Set("firmware", vm.Firmware)
+	d.Set("secure_boot", vm.SecureBoot)
+	d.Set("cores_per_socket", vm.Tuning.CoresPerSocket)
+	d.Set("nested_virtualization", *vm.Tuning.NestedVirtualization)
+	d.Set("performance_counters", *vm.Tuning.PerformanceCounters)
+	d.Set("page_sharing", *vm.Tuning.PageSharing)
+	if vm.Tuning.ShareScanTotal != nil {
+		d.Set("share_scan_total", *vm.Tuning.ShareScanTotal)
+	}
+	d.Set("balloon_limit", vm.Tuning.BalloonLimit)
+
+	if err := vnc_vix_to_tf(vm, d); err != nil {
+		return err
+	}
+	if vm.IPAddress != "" || vm.WaitForIP == nil {
+		d.Set("ip_address", vm.IPAddress)
+	}
 
 	err = net_vix_to_tf(vm, d)
 	if err != nil {
@@ -498,5 +1739,15 @@ func resourceVIXVMRead(d *schema.ResourceData, meta interface{}) error {
 		return err
 	}
 
+	err = shares_vix_to_tf(vm, d)
+	if err != nil {
+		return err
+	}
+
+	err = disk_vix_to_tf(vm, d)
+	if err != nil {
+		return err
+	}
+
 	return nil
 }
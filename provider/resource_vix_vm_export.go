@@ -0,0 +1,112 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+package provider
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	"github.com/hashicorp/terraform/helper/validation"
+	"github.com/hooklift/terraform-provider-vix/provider/vix"
+)
+
+func resourceVIXVMExport() *schema.Resource {
+	return &schema.Resource{
+		Create: resourceVIXVMExportCreate,
+		Read:   resourceVIXVMExportRead,
+		Delete: resourceVIXVMExportDelete,
+
+		Schema: map[string]*schema.Schema{
+			"vm_id": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			// Path of the package, it is removed on destroy
+			"output": &schema.Schema{
+				Type:     schema.TypeString,
+				Required: true,
+				ForceNew: true,
+			},
+			"format": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  vix.ExportFormatBox,
+				ForceNew: true,
+				ValidateFunc: validation.StringInSlice([]string{
+					vix.ExportFormatBox, vix.ExportFormatOVA,
+				}, false),
+			},
+			"consistency": &schema.Schema{
+				Type:     schema.TypeString,
+				Optional: true,
+				Default:  vix.ExportConsistencyPowerOff,
+				ForceNew: true,
+				ValidateFunc: validation.StringInSlice([]string{
+					vix.ExportConsistencyPowerOff, vix.ExportConsistencySnapshot,
+				}, false),
+			},
+			// Arbitrary values that export the VM again when they change, as
+			// changes to the VM itself are not tracked.
+			"triggers": &schema.Schema{
+				Type:     schema.TypeMap,
+				Optional: true,
+				ForceNew: true,
+			},
+			"sha256": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+			// file:// URL of the package, so it can be used as image url
+			"url": &schema.Schema{
+				Type:     schema.TypeString,
+				Computed: true,
+			},
+		},
+	}
+}
+
+func resourceVIXVMExportCreate(d *schema.ResourceData, meta interface{}) error {
+	output, err := filepath.Abs(d.Get("output").(string))
+	if err != nil {
+		return err
+	}
+
+	vm := newSnapshotVM(meta)
+	sum, err := vm.Export(d.Get("vm_id").(string), &vix.Export{
+		Format:      d.Get("format").(string),
+		Output:      output,
+		Consistency: d.Get("consistency").(string),
+	})
+	if err != nil {
+		return err
+	}
+
+	d.SetId(output)
+	d.Set("sha256", sum)
+	d.Set("url", "file://"+filepath.ToSlash(output))
+
+	return resourceVIXVMExportRead(d, meta)
+}
+
+func resourceVIXVMExportRead(d *schema.ResourceData, meta interface{}) error {
+	// Packages are not checksummed again as they can be quite large
+	if _, err := os.Stat(d.Id()); os.IsNotExist(err) {
+		log.Printf("[WARN] Package %s is gone", d.Id())
+		d.SetId("")
+	}
+
+	return nil
+}
+
+func resourceVIXVMExportDelete(d *schema.ResourceData, meta interface{}) error {
+	log.Printf("[INFO] Removing package %s", d.Id())
+	if err := os.Remove(d.Id()); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return nil
+}
@@ -2,3 +2,245 @@
 // License, v. 2.0. If a copy of the MPL was not distributed with this
 // file, You can obtain one at http://mozilla.org/MPL/2.0/.
 package provider
+
+import (
+	"net"
+	"testing"
+
+	"github.com/hashicorp/terraform/helper/schema"
+	govix "github.com/hooklift/govix"
+	"github.com/hooklift/terraform-provider-vix/provider/vix"
+)
+
+func TestNormalizeMemory(t *testing.T) {
+	for size, expected := range map[string]string{
+		"1gib":    "1024mib",
+		"1.0 gib": "1024mib",
+		"512mib":  "512mib",
+		"lots":    "lots",
+	} {
+		if actual := normalizeMemory(size); actual != expected {
+			t.Errorf("normalizeMemory(%q) = %q, expected %q", size, actual, expected)
+		}
+	}
+
+	if !suppressMemoryDiff("memory", "1024mib", "1.0gib", nil) {
+		t.Error("1024mib and 1.0gib are the same memory size")
+	}
+}
+
+func TestNetVIXToTF(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, resourceVIXVM().Schema, map[string]interface{}{
+		"network_adapter": []interface{}{
+			map[string]interface{}{"type": "nat"},
+			map[string]interface{}{"type": "custom", "vswitch": "vmnet2"},
+		},
+	})
+
+	vm := &vix.VM{
+		VNetworkAdapters: []*govix.NetworkAdapter{
+			{ID: "0", ConnType: govix.NETWORK_NAT, Vdevice: govix.NETWORK_DEVICE_E1000},
+			{ID: "1", ConnType: govix.NETWORK_CUSTOM, Vdevice: govix.NETWORK_DEVICE_VMXNET3},
+		},
+		VSwitches: []string{"", "vmnet2"},
+	}
+	if err := net_vix_to_tf(vm, d); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	for attr, expected := range map[string]interface{}{
+		"network_adapter.#":         2,
+		"network_adapter.0.type":    "nat",
+		"network_adapter.0.vswitch": "",
+		"network_adapter.1.type":    "custom",
+		"network_adapter.1.vswitch": "vmnet2",
+		"network_adapter.1.driver":  "vmxnet3",
+	} {
+		if actual := d.Get(attr); actual != expected {
+			t.Errorf("%s = %#v, expected %#v", attr, actual, expected)
+		}
+	}
+}
+
+func TestNetVIXToTFComputed(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, resourceVIXVM().Schema, map[string]interface{}{
+		"network_adapter": []interface{}{
+			map[string]interface{}{"type": "nat"},
+		},
+	})
+
+	generated, _ := net.ParseMAC("00:0c:29:1a:2b:3c")
+	vm := &vix.VM{
+		VNetworkAdapters: []*govix.NetworkAdapter{{
+			ID:                  "0",
+			ConnType:            govix.NETWORK_NAT,
+			GeneratedMacAddress: generated,
+			PciSlotNumber:       "33",
+			StartConnected:      true,
+			WakeOnPcktRcv:       true,
+		}},
+	}
+	if err := net_vix_to_tf(vm, d); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	for attr, expected := range map[string]interface{}{
+		"network_adapter.0.generated_mac_address": "00:0c:29:1a:2b:3c",
+		"network_adapter.0.pci_slot_number":       33,
+		"network_adapter.0.start_connected":       true,
+		"network_adapter.0.wake_on_lan":           true,
+		"network_adapter.0.mac_address_type":      "generated",
+	} {
+		if actual := d.Get(attr); actual != expected {
+			t.Errorf("%s = %#v, expected %#v", attr, actual, expected)
+		}
+	}
+}
+
+func TestNetVIXToTFAutoStatic(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, resourceVIXVM().Schema, map[string]interface{}{
+		"network_adapter": []interface{}{
+			map[string]interface{}{"type": "nat", "mac_address_type": "auto_static"},
+		},
+	})
+
+	// Auto static adapters are attached as static ones
+	mac, _ := net.ParseMAC("00:50:56:12:34:56")
+	vm := &vix.VM{
+		VNetworkAdapters: []*govix.NetworkAdapter{{
+			ID:          "0",
+			ConnType:    govix.NETWORK_NAT,
+			MacAddrType: govix.NETWORK_MACADDRESSTYPE_STATIC,
+			MacAddress:  mac,
+		}},
+	}
+	if err := net_vix_to_tf(vm, d); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	for attr, expected := range map[string]interface{}{
+		"network_adapter.0.mac_address":      "00:50:56:12:34:56",
+		"network_adapter.0.mac_address_type": "auto_static",
+	} {
+		if actual := d.Get(attr); actual != expected {
+			t.Errorf("%s = %#v, expected %#v", attr, actual, expected)
+		}
+	}
+
+	// The derived address is kept on the next update
+	if err := net_tf_to_vix(d, vm); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	if actual := vm.VNetworkAdapters[0].MacAddress.String(); actual != "00:50:56:12:34:56" {
+		t.Errorf("MAC address = %q, expected the one in state", actual)
+	}
+}
+
+func TestGuestExecVIXToTF(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, resourceVIXVM().Schema, map[string]interface{}{
+		"guest_exec": []interface{}{
+			map[string]interface{}{"username": "core", "password": "secret", "script": "uptime"},
+			map[string]interface{}{"username": "core", "password": "secret", "script": "false", "when": "update"},
+		},
+	})
+
+	// The second script did not run
+	vm := &vix.VM{
+		GuestExecs: []*vix.GuestExec{{PID: 42, ExitCode: 3}, {}},
+	}
+	if err := guestexec_vix_to_tf(vm, d); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	for attr, expected := range map[string]interface{}{
+		"guest_exec.#":           2,
+		"guest_exec.0.pid":       42,
+		"guest_exec.0.exit_code": 3,
+		"guest_exec.0.script":    "uptime",
+		"guest_exec.1.pid":       0,
+		"guest_exec.1.when":      "update",
+	} {
+		if actual := d.Get(attr); actual != expected {
+			t.Errorf("%s = %#v, expected %#v", attr, actual, expected)
+		}
+	}
+}
+
+func TestGuestFileVIXToTF(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, resourceVIXVM().Schema, map[string]interface{}{
+		"guest_file": []interface{}{
+			map[string]interface{}{"content": "hello", "destination": "/etc/motd"},
+		},
+	})
+
+	vm := &vix.VM{}
+	if err := guestfile_tf_to_vix(d, vm); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+	vm.GuestFiles[0].Checksum = "abc123"
+
+	if err := guestfile_vix_to_tf(vm, d); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	if actual := d.Get("guest_file.0.checksum"); actual != "abc123" {
+		t.Errorf("guest_file.0.checksum = %#v, expected the checksum of the upload", actual)
+	}
+	if actual := d.Get("guest_file.0.destination"); actual != "/etc/motd" {
+		t.Errorf("guest_file.0.destination = %#v, expected /etc/motd", actual)
+	}
+}
+
+func TestSharesVIXToTF(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, resourceVIXVM().Schema, map[string]interface{}{
+		"shared_folder": []interface{}{
+			map[string]interface{}{"name": "Dev1", "enable": true, "host_path": "/src", "readonly": true},
+		},
+	})
+
+	// The share was made writable outside of Terraform
+	vm := &vix.VM{
+		Shares: []*vix.SharedFolder{
+			{Name: "Dev1", Enable: true, HostPath: "/src", GuestPath: "/mnt/hgfs/Dev1"},
+		},
+	}
+	if err := shares_vix_to_tf(vm, d); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	for attr, expected := range map[string]interface{}{
+		"shared_folder.#":            1,
+		"shared_folder.0.readonly":   false,
+		"shared_folder.0.guest_path": "/mnt/hgfs/Dev1",
+	} {
+		if actual := d.Get(attr); actual != expected {
+			t.Errorf("%s = %#v, expected %#v", attr, actual, expected)
+		}
+	}
+}
+
+func TestDiskVIXToTF(t *testing.T) {
+	d := schema.TestResourceDataRaw(t, resourceVIXVM().Schema, map[string]interface{}{})
+
+	vm := &vix.VM{
+		Disks: []*vix.Disk{
+			{Bus: "scsi", Controller: 0, Unit: 1, Size: "10GB", Mode: "persistent", Path: "/vms/data.vmdk", KeepFile: true},
+		},
+	}
+	if err := disk_vix_to_tf(vm, d); err != nil {
+		t.Fatalf("err: %s", err)
+	}
+
+	for attr, expected := range map[string]interface{}{
+		"disk.#":           1,
+		"disk.0.bus":       "scsi",
+		"disk.0.unit":      1,
+		"disk.0.size":      "10GB",
+		"disk.0.path":      "/vms/data.vmdk",
+		"disk.0.keep_file": true,
+	} {
+		if actual := d.Get(attr); actual != expected {
+			t.Errorf("%s = %#v, expected %#v", attr, actual, expected)
+		}
+	}
+}
@@ -14,9 +14,10 @@ func resourceVIXVSwitch() *schema.Resource {
 
 		Schema: map[string]*schema.Schema{
 			"name": &schema.Schema{
-				Type:     schema.TypeString,
-				Required: true,
-				ForceNew: true,
+				Type:         schema.TypeString,
+				Required:     true,
+				ForceNew:     true,
+				ValidateFunc: validateVSwitchName,
 			},
 			"nat": &schema.Schema{
 				Type:     schema.TypeBool,
@@ -34,8 +35,9 @@ func resourceVIXVSwitch() *schema.Resource {
 				Default:  true,
 			},
 			"range": &schema.Schema{
-				Type:     schema.TypeString,
-				Optional: true,
+				Type:         schema.TypeString,
+				Optional:     true,
+				ValidateFunc: validateCIDR,
 			},
 		},
 	}
@@ -44,6 +46,10 @@ func resourceVIXVSwitch() *schema.Resource {
 func resourceVIXVSwitchCreate(d *schema.ResourceData, meta interface{}) error {
 	//config := meta.(*Config)
 
+	// Virtual switches are identified by their vmnet name so network adapters
+	// can reference them either way.
+	d.SetId(d.Get("name").(string))
+
 	return nil
 }
 
@@ -0,0 +1,112 @@
+// This Source Code Form is subject to the terms of the Mozilla Public
+// License, v. 2.0. If a copy of the MPL was not distributed with this
+// file, You can obtain one at http://mozilla.org/MPL/2.0/.
+package provider
+
+import (
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/dustin/go-humanize"
+	"github.com/hooklift/terraform-provider-vix/provider/vix"
+)
+
+// Makes sure static MAC addresses are within VMware's static range.
+func validateStaticMAC(v interface{}, k string) ([]string, []error) {
+	value := v.(string)
+	if value == "" {
+		return nil, nil
+	}
+
+	if _, err := vix.ParseStaticMAC(value); err != nil {
+		return nil, []error{fmt.Errorf("%s: %s", k, err)}
+	}
+	return nil, nil
+}
+
+// Makes sure virtual switches are named after a vmnet, such as vmnet2 or
+// /dev/vmnet2.
+func validateVSwitchName(v interface{}, k string) ([]string, []error) {
+	if _, err := vix.VSwitchName(v.(string)); err != nil {
+		return nil, []error{fmt.Errorf("%s: %s", k, err)}
+	}
+	return nil, nil
+}
+
+func validateDuration(v interface{}, k string) ([]string, []error) {
+	if _, err := time.ParseDuration(v.(string)); err != nil {
+		return nil, []error{fmt.Errorf("%s: %s", k, err)}
+	}
+	return nil, nil
+}
+
+func validateCIDR(v interface{}, k string) ([]string, []error) {
+	if _, _, err := net.ParseCIDR(v.(string)); err != nil {
+		return nil, []error{fmt.Errorf("%s: %s", k, err)}
+	}
+	return nil, nil
+}
+
+var guestInfoNameRegexp = regexp.MustCompile(`^[A-Za-z0-9_.-]+$`)
+
+// Makes sure guestinfo variable names are usable as VMX keys. Names are given
+// without the guestinfo prefix and encoding keys are managed by the provider.
+// Names have to be lowercase, so names differing only in case can not clash.
+func validateGuestInfo(v interface{}, k string) ([]string, []error) {
+	var errs []error
+	for name := range v.(map[string]interface{}) {
+		switch {
+		case !guestInfoNameRegexp.MatchString(name):
+			errs = append(errs, fmt.Errorf("%s: invalid variable name %q", k, name))
+		case strings.ToLower(name) != name:
+			errs = append(errs, fmt.Errorf("%s: %q must be lowercase, VMX keys are case insensitive", k, name))
+		case strings.HasPrefix(name, "guestinfo."):
+			errs = append(errs, fmt.Errorf("%s: %q must not include the guestinfo. prefix", k, name))
+		case strings.HasSuffix(name, ".encoding"):
+			errs = append(errs, fmt.Errorf("%s: %q is managed by the provider, values are always base64 encoded", k, name))
+		}
+	}
+	return nil, errs
+}
+
+var fileModeRegexp = regexp.MustCompile(`^0?[0-7]{3}$`)
+
+func validateFileMode(v interface{}, k string) ([]string, []error) {
+	if !fileModeRegexp.MatchString(v.(string)) {
+		return nil, []error{fmt.Errorf("%s: %q is not an octal file mode, ie: 0644", k, v)}
+	}
+	return nil, nil
+}
+
+// Makes sure memory sizes are multiples of 4mib, as VMware requires.
+func validateMemory(v interface{}, k string) ([]string, []error) {
+	if _, err := vix.ParseMemory(v.(string)); err != nil {
+		return nil, []error{fmt.Errorf("%s: %s", k, err)}
+	}
+	return nil, nil
+}
+
+// Makes sure memory limits are sizes humanize understands, zero included
+func validateMemoryLimit(v interface{}, k string) ([]string, []error) {
+	if _, err := humanize.ParseBytes(v.(string)); err != nil {
+		return nil, []error{fmt.Errorf("%s: %s", k, err)}
+	}
+	return nil, nil
+}
+
+// Makes sure sizes are given in bytes or with a unit humanize understands,
+// such as 40gib.
+func validateSize(v interface{}, k string) ([]string, []error) {
+	size, err := humanize.ParseBytes(v.(string))
+	if err != nil {
+		return nil, []error{fmt.Errorf("%s: %s", k, err)}
+	}
+
+	if size == 0 {
+		return nil, []error{fmt.Errorf("%s: has to be greater than zero", k)}
+	}
+	return nil, nil
+}
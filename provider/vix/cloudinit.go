@@ -0,0 +1,56 @@
+package vix
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+)
+
+// Name of the NoCloud seed image written next to the VMX file
+const cloudInitISO = "cidata.iso"
+
+// Volume label cloud-init looks for in order to find NoCloud seed images
+const cloudInitLabel = "cidata"
+
+// cloud-init NoCloud data source configuration
+type CloudInit struct {
+	// Contents of user-data
+	UserData string
+	// Contents of meta-data. When empty, the VM name is used as instance-id
+	// and hostname.
+	MetaData string
+	// Contents of network-config, left out if empty
+	NetworkConfig string
+}
+
+// Writes the NoCloud seed image for the virtual machine in vmDir, returning
+// its path. Any image left from a previous run is replaced.
+func (v *VM) writeCloudInitISO(vmDir string) (string, error) {
+	metadata := v.CloudInit.MetaData
+	if metadata == "" {
+		metadata = "instance-id: " + v.Name + "\nlocal-hostname: " + v.Name + "\n"
+	}
+
+	files := map[string][]byte{
+		"user-data": []byte(v.CloudInit.UserData),
+		"meta-data": []byte(metadata),
+	}
+
+	if v.CloudInit.NetworkConfig != "" {
+		files["network-config"] = []byte(v.CloudInit.NetworkConfig)
+	}
+
+	path := filepath.Join(vmDir, cloudInitISO)
+	log.Printf("[DEBUG] Writing cloud-init seed image to %s", path)
+
+	return path, writeISO(path, cloudInitLabel, files)
+}
+
+// Removes the NoCloud seed image once cloud-init is no longer configured.
+func removeCloudInitISO(vmDir string) error {
+	err := os.Remove(filepath.Join(vmDir, cloudInitISO))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	return err
+}
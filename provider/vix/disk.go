@@ -0,0 +1,378 @@
+package vix
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+)
+
+// Disk modes
+const (
+	DiskModePersistent               = "persistent"
+	DiskModeNonPersistent            = "nonpersistent"
+	DiskModeIndependentPersistent    = "independent-persistent"
+	DiskModeIndependentNonPersistent = "independent-nonpersistent"
+)
+
+// Virtual disk attached to the virtual machine, besides the disks coming from
+// its image
+type Disk struct {
+	// Either ide, scsi, sata or nvme
+	Bus string
+	// Controller and unit numbers, i.e. scsi1:2 is unit 2 of controller 1
+	Controller int
+	Unit       int
+	// Size of the disk, such as 10gib. It is only used to create new disks.
+	Size string
+	// One of the disk modes, VMware defaults to persistent
+	Mode string
+	// Existing vmdk file to attach. When empty, a disk is created in the
+	// virtual machine directory.
+	Path string
+	// Whether to keep the vmdk file around once the disk is detached
+	KeepFile bool
+}
+
+// Identifies the disk in the VMX file, ie: scsi1:2
+func (d *Disk) ID() string {
+	return fmt.Sprintf("%s%d:%d", d.Bus, d.Controller, d.Unit)
+}
+
+// Key prefix of the disk controller in the VMX file, ie: scsi1
+func (d *Disk) controllerID() string {
+	return fmt.Sprintf("%s%d", d.Bus, d.Controller)
+}
+
+// Name of the vmdk file created for disks without a path
+func (d *Disk) fileName(vmName string) string {
+	return fmt.Sprintf("%s-%s%d-%d.vmdk", vmName, d.Bus, d.Controller, d.Unit)
+}
+
+// Adapter type written to the descriptor of new disks
+func (d *Disk) adapterType() string {
+	if d.Bus == "ide" {
+		return "ide"
+	}
+	return "lsilogic"
+}
+
+// Makes sure the disk fits in its controller. VMware supports up to 2 IDE
+// controllers with 2 units each, and 4 SCSI, SATA or NVMe controllers with
+// 16, 30 and 15 units respectively. Unit 7 of SCSI controllers is reserved
+// for the controller itself.
+func (d *Disk) Validate() error {
+	maxControllers, maxUnits := 4, 0
+	switch d.Bus {
+	case "ide":
+		maxControllers, maxUnits = 2, 2
+	case "scsi":
+		maxUnits = 16
+	case "sata":
+		maxUnits = 30
+	case "nvme":
+		maxUnits = 15
+	default:
+		return fmt.Errorf("[ERROR] Invalid disk bus: %s", d.Bus)
+	}
+
+	if d.Controller < 0 || d.Controller >= maxControllers {
+		return fmt.Errorf("[ERROR] Invalid %s controller %d, it has to be lower than %d",
+			d.Bus, d.Controller, maxControllers)
+	}
+
+	if d.Unit < 0 || d.Unit >= maxUnits || (d.Bus == "scsi" && d.Unit == 7) {
+		return fmt.Errorf("[ERROR] Invalid %s unit %d", d.ID(), d.Unit)
+	}
+
+	return nil
+}
+
+var vmdkSnapshotSuffix = regexp.MustCompile(`-\d{6}\.vmdk$`)
+
+// Strips the suffix VMware adds to vmdk files once snapshots are taken, i.e.
+// disk-000001.vmdk is a delta of disk.vmdk.
+func vmdkBase(filename string) string {
+	return vmdkSnapshotSuffix.ReplaceAllString(filename, ".vmdk")
+}
+
+// Resolves vmdk file names relative to the VMX file
+func vmdkPath(vmDir, filename string) string {
+	if filename == "" || filepath.IsAbs(filename) {
+		return filename
+	}
+	return filepath.Join(vmDir, filename)
+}
+
+// Removes the entries of the given disks, and of their controllers once they
+// are left without devices, from the VMX file. GoVMX renumbers disk
+// controllers and devices when rewriting the VMX file, and it drops NVMe ones
+// altogether, so disks are detached before GoVMX touches the file and
+// attached again right before powering the virtual machine on. It returns the
+// entries removed, indexed by disk ID.
+func detachDisks(vmxFile string, disks []*Disk) (map[string]map[string]string, error) {
+	detached := make(map[string]map[string]string)
+
+	err := updateVMX(vmxFile, func(vmx map[string]string) error {
+		controllers := make(map[string]bool)
+		for _, disk := range disks {
+			prefix := disk.ID() + "."
+			for key, value := range vmx {
+				if !strings.HasPrefix(key, prefix) {
+					continue
+				}
+
+				if detached[disk.ID()] == nil {
+					detached[disk.ID()] = make(map[string]string)
+				}
+				detached[disk.ID()][strings.TrimPrefix(key, prefix)] = value
+				delete(vmx, key)
+			}
+			controllers[disk.controllerID()] = true
+		}
+
+		for controller := range controllers {
+			inUse := false
+			for key := range vmx {
+				if strings.HasPrefix(key, controller+":") {
+					inUse = true
+					break
+				}
+			}
+
+			if inUse {
+				continue
+			}
+
+			for key := range vmx {
+				if strings.HasPrefix(key, controller+".") {
+					delete(vmx, key)
+				}
+			}
+		}
+
+		return nil
+	})
+
+	return detached, err
+}
+
+// Creates missing vmdk files and attaches disks to the VMX file. detached are
+// the entries removed by detachDisks, file names are carried over from them
+// so disks keep pointing to their latest snapshot delta.
+func (v *VM) attachDisks(vmxFile string, detached map[string]map[string]string) error {
+	if len(v.Disks) == 0 {
+		return nil
+	}
+
+	vmDir := filepath.Dir(vmxFile)
+
+	return updateVMX(vmxFile, func(vmx map[string]string) error {
+		hwVersion, err := strconv.Atoi(vmx["virtualhw.version"])
+		if err != nil {
+			hwVersion = scratchHardwareVersion
+		}
+
+		for _, disk := range v.Disks {
+			id := disk.ID()
+			if present, ok := vmx[id+".present"]; ok && !strings.EqualFold(present, "false") {
+				return fmt.Errorf("[ERROR] Unable to attach disk %s, the slot is taken by "+
+					"another device", id)
+			}
+
+			filename := disk.Path
+			if filename == "" {
+				filename = disk.fileName(v.Name)
+
+				path := vmdkPath(vmDir, filename)
+				if _, err := os.Stat(path); os.IsNotExist(err) {
+					size, err := humanize.ParseBytes(disk.Size)
+					if err != nil {
+						return fmt.Errorf("[ERROR] Invalid size for disk %s: %s", id, err)
+					}
+
+					if err = createSparseVMDK(path, size, disk.adapterType(), hwVersion); err != nil {
+						return err
+					}
+				}
+			}
+
+			if previous := detached[id]["filename"]; previous != "" &&
+				vmdkBase(vmdkPath(vmDir, previous)) == vmdkPath(vmDir, filename) {
+				filename = previous
+			}
+
+			controller := disk.controllerID()
+			vmx[controller+".present"] = "TRUE"
+			if disk.Bus == "scsi" && vmx[controller+".virtualdev"] == "" {
+				vmx[controller+".virtualdev"] = "lsilogic"
+			}
+
+			log.Printf("[DEBUG] Attaching disk %s: %s", id, filename)
+			vmx[id+".present"] = "TRUE"
+			vmx[id+".filename"] = filename
+			if disk.Mode != "" {
+				vmx[id+".mode"] = disk.Mode
+			}
+		}
+
+		return nil
+	})
+}
+
+// Removes the vmdk files of detached disks, unless they were asked to be
+// kept. Only files created by the provider are removed, existing disks given
+// by path are always kept.
+func (v *VM) removeDetachedDisks(vmxFile string) error {
+	vmDir := filepath.Dir(vmxFile)
+
+	for _, disk := range v.DetachedDisks {
+		if disk.KeepFile {
+			continue
+		}
+
+		path := vmdkPath(vmDir, disk.fileName(v.Name))
+		if disk.Path != "" && vmdkPath(vmDir, disk.Path) != path {
+			continue
+		}
+
+		log.Printf("[INFO] Removing disk %s: %s", disk.ID(), path)
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Refreshes v.Disks with the entries found in the VMX file. Disks that are no
+// longer attached are left out, so they get attached again.
+func (v *VM) readDisks(vmxFile string) error {
+	vmx, err := readVMX(vmxFile)
+	if err != nil {
+		return err
+	}
+
+	vmDir := filepath.Dir(vmxFile)
+
+	disks := make([]*Disk, 0, len(v.Disks))
+	for _, disk := range v.Disks {
+		id := disk.ID()
+		present, ok := vmx[id+".present"]
+		if !ok || strings.EqualFold(present, "false") {
+			log.Printf("[WARN] Disk %s is no longer attached", id)
+			continue
+		}
+
+		disk.Path = vmdkBase(vmdkPath(vmDir, vmx[id+".filename"]))
+		disk.Mode = vmx[id+".mode"]
+		if disk.Mode == "" {
+			disk.Mode = DiskModePersistent
+		}
+
+		disks = append(disks, disk)
+	}
+	v.Disks = disks
+
+	return nil
+}
+
+var diskFilenameRegexp = regexp.MustCompile(`^(ide|scsi|sata|nvme)(\d+):(\d+)\.filename$`)
+
+// Boot order of disk buses when bios.hddorder is not set
+var diskBusOrder = map[string]int{"scsi": 0, "sata": 1, "nvme": 2, "ide": 3}
+
+// Lists the virtual disks attached to the VMX file, leaving CD/DVD drives
+// out. Paths are as found in the VMX file.
+func vmxDisks(vmx map[string]string) []*Disk {
+	var disks []*Disk
+	for key, filename := range vmx {
+		m := diskFilenameRegexp.FindStringSubmatch(key)
+		if m == nil || !strings.HasSuffix(strings.ToLower(filename), ".vmdk") {
+			continue
+		}
+
+		controller, _ := strconv.Atoi(m[2])
+		unit, _ := strconv.Atoi(m[3])
+		disk := &Disk{Bus: m[1], Controller: controller, Unit: unit, Path: filename}
+		id := disk.ID()
+
+		if strings.EqualFold(vmx[id+".present"], "false") ||
+			strings.Contains(vmx[id+".devicetype"], "cdrom") {
+			continue
+		}
+		disks = append(disks, disk)
+	}
+
+	return disks
+}
+
+// Finds the vmdk file of the disk the virtual machine boots from: the first
+// one in bios.hddorder or else the first one by bus, controller and unit.
+// Disks in v.Disks are not considered.
+func (v *VM) bootDisk(vmxFile string) (string, error) {
+	vmx, err := readVMX(vmxFile)
+	if err != nil {
+		return "", err
+	}
+
+	managed := make(map[string]bool, len(v.Disks))
+	for _, disk := range v.Disks {
+		managed[disk.ID()] = true
+	}
+
+	var disks []*Disk
+	for _, disk := range vmxDisks(vmx) {
+		if !managed[disk.ID()] {
+			disks = append(disks, disk)
+		}
+	}
+
+	if len(disks) == 0 {
+		return "", fmt.Errorf("[ERROR] No disks found in %s", vmxFile)
+	}
+
+	sortDisks(disks, vmx["bios.hddorder"])
+
+	return vmdkPath(filepath.Dir(vmxFile), disks[0].Path), nil
+}
+
+// Sorts disks in boot order: the first one in hddOrder, as found in
+// bios.hddorder, and then by bus, controller and unit.
+func sortDisks(disks []*Disk, hddOrder string) {
+	first := strings.TrimSpace(strings.Split(hddOrder, ",")[0])
+	sort.Slice(disks, func(i, j int) bool {
+		a, b := disks[i], disks[j]
+		switch {
+		case a.ID() == first || b.ID() == first:
+			return a.ID() == first
+		case a.Bus != b.Bus:
+			return diskBusOrder[a.Bus] < diskBusOrder[b.Bus]
+		case a.Controller != b.Controller:
+			return a.Controller < b.Controller
+		default:
+			return a.Unit < b.Unit
+		}
+	})
+}
+
+// Grows the boot disk to v.DiskSize. Boxes usually ship with small disks.
+func (v *VM) growBootDisk(vmxFile string) error {
+	size, err := humanize.ParseBytes(v.DiskSize)
+	if err != nil {
+		return fmt.Errorf("[ERROR] Invalid disk size %q: %s", v.DiskSize, err)
+	}
+
+	disk, err := v.bootDisk(vmxFile)
+	if err != nil {
+		return err
+	}
+
+	return growVMDK(disk, size)
+}
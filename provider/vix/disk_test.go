@@ -0,0 +1,159 @@
+package vix
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestDiskValidate(t *testing.T) {
+	valid := []*Disk{
+		{Bus: "ide", Controller: 1, Unit: 1},
+		{Bus: "scsi", Controller: 3, Unit: 15},
+		{Bus: "sata", Controller: 0, Unit: 29},
+		{Bus: "nvme", Controller: 0, Unit: 14},
+	}
+	for _, disk := range valid {
+		ok(t, disk.Validate())
+	}
+
+	invalid := []*Disk{
+		{Bus: "ide", Controller: 2, Unit: 0},
+		{Bus: "ide", Controller: 0, Unit: 2},
+		{Bus: "scsi", Controller: 0, Unit: 7},
+		{Bus: "nvme", Controller: 0, Unit: 15},
+		{Bus: "floppy", Controller: 0, Unit: 0},
+	}
+	for _, disk := range invalid {
+		assert(t, disk.Validate() != nil, "%s should be invalid", disk.ID())
+	}
+}
+
+func TestVMDKBase(t *testing.T) {
+	equals(t, "/vms/web01-scsi1-0.vmdk", vmdkBase("/vms/web01-scsi1-0-000002.vmdk"))
+	equals(t, "web01.vmdk", vmdkBase("web01.vmdk"))
+}
+
+func TestAttachDisks(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "terraform-vix")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+
+	vmxFile := filepath.Join(dir, "web01.vmx")
+	ok(t, writeVMX(vmxFile, map[string]string{
+		"virtualhw.version": "11",
+		"scsi0.present":     "TRUE",
+		"scsi0.virtualdev":  "lsilogic",
+		"scsi0:0.present":   "TRUE",
+		"scsi0:0.filename":  "web01.vmdk",
+	}))
+
+	v := &VM{
+		Name: "web01",
+		Disks: []*Disk{
+			{Bus: "scsi", Controller: 0, Unit: 1, Size: "1gib", Mode: DiskModeIndependentPersistent},
+			{Bus: "nvme", Controller: 0, Unit: 0, Size: "1gib"},
+		},
+	}
+
+	detached, err := detachDisks(vmxFile, v.Disks)
+	ok(t, err)
+	equals(t, 0, len(detached))
+	ok(t, v.attachDisks(vmxFile, detached))
+
+	vmx, err := readVMX(vmxFile)
+	ok(t, err)
+	equals(t, "web01-scsi0-1.vmdk", vmx["scsi0:1.filename"])
+	equals(t, DiskModeIndependentPersistent, vmx["scsi0:1.mode"])
+	equals(t, "TRUE", vmx["nvme0.present"])
+	equals(t, "web01-nvme0-0.vmdk", vmx["nvme0:0.filename"])
+
+	_, err = os.Stat(filepath.Join(dir, "web01-nvme0-0.vmdk"))
+	ok(t, err)
+
+	// VMware points disks to their delta once snapshots are taken
+	ok(t, updateVMX(vmxFile, func(vmx map[string]string) error {
+		vmx["scsi0:1.filename"] = "web01-scsi0-1-000001.vmdk"
+		return nil
+	}))
+
+	// NVMe controllers are removed along with their last disk, SCSI ones are
+	// kept as long as other devices use them
+	detached, err = detachDisks(vmxFile, v.Disks)
+	ok(t, err)
+	equals(t, "web01-scsi0-1-000001.vmdk", detached["scsi0:1"]["filename"])
+
+	vmx, err = readVMX(vmxFile)
+	ok(t, err)
+	equals(t, "", vmx["scsi0:1.filename"])
+	equals(t, "", vmx["nvme0.present"])
+	equals(t, "lsilogic", vmx["scsi0.virtualdev"])
+
+	ok(t, v.attachDisks(vmxFile, detached))
+	vmx, err = readVMX(vmxFile)
+	ok(t, err)
+	equals(t, "web01-scsi0-1-000001.vmdk", vmx["scsi0:1.filename"])
+
+	// Disks are read back from the VMX file
+	read := &VM{Disks: []*Disk{
+		{Bus: "scsi", Controller: 0, Unit: 1},
+		{Bus: "sata", Controller: 0, Unit: 0},
+	}}
+	ok(t, read.readDisks(vmxFile))
+	equals(t, 1, len(read.Disks))
+	equals(t, filepath.Join(dir, "web01-scsi0-1.vmdk"), read.Disks[0].Path)
+	equals(t, DiskModeIndependentPersistent, read.Disks[0].Mode)
+
+	// Slots taken by other devices are not overwritten
+	taken := &VM{Name: "web01", Disks: []*Disk{{Bus: "scsi", Controller: 0, Unit: 0, Size: "1gib"}}}
+	assert(t, taken.attachDisks(vmxFile, nil) != nil, "scsi0:0 is taken by the boot disk")
+
+	// Only files created by the provider are removed
+	external := filepath.Join(dir, "external.vmdk")
+	ok(t, ioutil.WriteFile(external, nil, 0644))
+
+	v.DetachedDisks = []*Disk{
+		{Bus: "nvme", Controller: 0, Unit: 0},
+		{Bus: "sata", Controller: 0, Unit: 1, Path: external},
+		{Bus: "scsi", Controller: 0, Unit: 1, KeepFile: true},
+	}
+	ok(t, v.removeDetachedDisks(vmxFile))
+
+	_, err = os.Stat(filepath.Join(dir, "web01-nvme0-0.vmdk"))
+	assert(t, os.IsNotExist(err), "nvme0:0 should have been removed")
+	_, err = os.Stat(external)
+	ok(t, err)
+}
+
+func TestBootDisk(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "terraform-vix")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+
+	vmxFile := filepath.Join(dir, "web01.vmx")
+	vmx := map[string]string{
+		"ide1:0.present":    "TRUE",
+		"ide1:0.devicetype": "cdrom-image",
+		"ide1:0.filename":   "/isos/ubuntu.iso",
+		"sata0:0.present":   "TRUE",
+		"sata0:0.filename":  "data.vmdk",
+		"scsi0:1.present":   "TRUE",
+		"scsi0:1.filename":  "web01-scsi0-1.vmdk",
+		"scsi0:2.present":   "TRUE",
+		"scsi0:2.filename":  "web01-000001.vmdk",
+	}
+	ok(t, writeVMX(vmxFile, vmx))
+
+	v := &VM{Disks: []*Disk{{Bus: "scsi", Controller: 0, Unit: 1}}}
+	disk, err := v.bootDisk(vmxFile)
+	ok(t, err)
+	equals(t, filepath.Join(dir, "web01-000001.vmdk"), disk)
+
+	vmx["bios.hddorder"] = "sata0:0"
+	ok(t, writeVMX(vmxFile, vmx))
+
+	disk, err = v.bootDisk(vmxFile)
+	ok(t, err)
+	equals(t, filepath.Join(dir, "data.vmdk"), disk)
+}
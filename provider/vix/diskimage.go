@@ -0,0 +1,211 @@
+package vix
+
+import (
+	"bufio"
+	"bytes"
+	"compress/bzip2"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/c4milo/unpackit"
+	"github.com/hooklift/govmx"
+	"github.com/ulikunitz/xz"
+)
+
+// Formats of plain disk images
+const (
+	ImageFormatQCOW2 = "qcow2"
+	ImageFormatRaw   = "raw"
+)
+
+// Whether the image is a plain disk, rather than a packaged virtual machine
+func (img *Image) isDisk() bool {
+	return img.Format == ImageFormatQCOW2 || img.Format == ImageFormatRaw
+}
+
+// Opens the decompressor for gzip, bzip2 and xz streams, nil if header does
+// not start with any of their magic numbers.
+func decompressor(header []byte, r io.Reader) (io.Reader, error) {
+	switch {
+	case bytes.HasPrefix(header, []byte{0x1f, 0x8b}):
+		return gzip.NewReader(r)
+	case bytes.HasPrefix(header, []byte("BZh")):
+		return bzip2.NewReader(r), nil
+	case bytes.HasPrefix(header, []byte{0xfd, '7', 'z', 'X', 'Z', 0x00}):
+		return xz.NewReader(r)
+	}
+	return nil, nil
+}
+
+// Extracts the disk out of compressed or archived disk images, such as
+// disk.img.xz or a tarball holding disk.qcow2, into dir. It returns an empty
+// path for plain disk images.
+func extractDiskImage(file *os.File, dir string) (string, error) {
+	if _, err := file.Seek(0, 0); err != nil {
+		return "", err
+	}
+
+	r := bufio.NewReader(file)
+	header, err := r.Peek(6)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+
+	if bytes.HasPrefix(header, []byte("PK\x03\x04")) {
+		log.Printf("[DEBUG] Unzipping disk image %s into %s", file.Name(), dir)
+		if _, err = unpackit.Unzip(r, dir); err != nil {
+			return "", err
+		}
+		return largestFile(dir)
+	}
+
+	compressed, err := decompressor(header, r)
+	if err != nil {
+		return "", err
+	}
+
+	if compressed != nil {
+		r = bufio.NewReader(compressed)
+	}
+
+	header, err = r.Peek(262)
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+
+	if len(header) == 262 && bytes.Equal(header[257:], []byte("ustar")) {
+		log.Printf("[DEBUG] Unpacking disk image %s into %s", file.Name(), dir)
+		if _, err = unpackit.Untar(r, dir); err != nil {
+			return "", err
+		}
+		return largestFile(dir)
+	}
+
+	if compressed == nil {
+		return "", nil
+	}
+
+	path := filepath.Join(dir, "disk")
+	log.Printf("[DEBUG] Decompressing disk image %s into %s", file.Name(), path)
+
+	disk, err := os.Create(path)
+	if err != nil {
+		return "", err
+	}
+	defer disk.Close()
+
+	if _, err = io.Copy(disk, r); err != nil {
+		return "", err
+	}
+
+	return path, nil
+}
+
+// Finds the largest file under dir, the disk of unpacked disk images
+func largestFile(dir string) (string, error) {
+	var largest string
+	var size int64
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		if info.Mode().IsRegular() && info.Size() > size {
+			largest, size = path, info.Size()
+		}
+		return nil
+	})
+
+	if err == nil && largest == "" {
+		err = fmt.Errorf("[ERROR] No disk image found in %s", dir)
+	}
+	return largest, err
+}
+
+// Converts the plain disk image in file into a monolithic sparse VMDK under
+// goldPath and writes a VMX file around it, so it can be cloned like any
+// other Gold virtual machine. The VMX file is built out of GuestOS, CPUs and
+// Memory, the same way virtual machines are created from scratch.
+func (v *VM) importDiskImage(file *os.File, goldPath string) (err error) {
+	v.SetDefaults()
+
+	if v.GuestOS == "" {
+		return fmt.Errorf("[ERROR] guest_os is required to import %s images", v.Image.Format)
+	}
+
+	if err = os.MkdirAll(goldPath, 0740); err != nil {
+		return err
+	}
+
+	// Leaves no half converted Gold virtual machine behind, it would be taken
+	// as a good one next time
+	defer func() {
+		if err != nil {
+			os.RemoveAll(goldPath)
+		}
+	}()
+
+	tmpDir, err := ioutil.TempDir(filepath.Dir(goldPath), "unpack-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tmpDir)
+
+	path, err := extractDiskImage(file, tmpDir)
+	if err != nil {
+		return err
+	}
+
+	if path != "" {
+		if file, err = os.Open(path); err != nil {
+			return err
+		}
+		defer file.Close()
+	}
+
+	var src io.ReaderAt = file
+	var size uint64
+	switch v.Image.Format {
+	case ImageFormatQCOW2:
+		img, err := openQCOW2(file)
+		if err != nil {
+			return err
+		}
+		src, size = img, img.Size()
+	case ImageFormatRaw:
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		size = uint64(info.Size())
+	default:
+		return fmt.Errorf("[ERROR] Unsupported image format: %s", v.Image.Format)
+	}
+
+	virtualDev, adapterType := scratchSCSIController(v.GuestOS)
+
+	disk := "disk.vmdk"
+	err = convertToSparseVMDK(filepath.Join(goldPath, disk), src, size, adapterType, scratchHardwareVersion)
+	if err != nil {
+		return err
+	}
+
+	model, err := v.scratchVMX(disk, virtualDev)
+	if err != nil {
+		return err
+	}
+
+	data, err := vmx.Marshal(model)
+	if err != nil {
+		return err
+	}
+
+	vmxFile := filepath.Join(goldPath, "image.vmx")
+	log.Printf("[INFO] Writing Gold virtual machine %s for %s image", vmxFile, v.Image.Format)
+	return ioutil.WriteFile(vmxFile, data, 0644)
+}
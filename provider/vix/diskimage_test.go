@@ -0,0 +1,170 @@
+package vix
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testClusterSize = 64 * 1024
+
+// Writes a qcow2 image of four 64KiB clusters: a standard one, an unallocated
+// one, a compressed one and a zero one.
+func writeTestQCOW2(t *testing.T, path string, standard, compressed []byte) {
+	var deflated bytes.Buffer
+	w, err := flate.NewWriter(&deflated, flate.BestCompression)
+	ok(t, err)
+	_, err = w.Write(compressed)
+	ok(t, err)
+	ok(t, w.Close())
+
+	file, err := os.Create(path)
+	ok(t, err)
+	defer file.Close()
+
+	ok(t, binary.Write(file, binary.BigEndian, &qcow2Header{
+		Magic:         qcow2Magic,
+		Version:       3,
+		ClusterBits:   16,
+		Size:          4 * testClusterSize,
+		L1Size:        1,
+		L1TableOffset: testClusterSize,
+	}))
+
+	l1 := []uint64{2 * testClusterSize}
+	_, err = file.Seek(testClusterSize, 0)
+	ok(t, err)
+	ok(t, binary.Write(file, binary.BigEndian, l1))
+
+	sectors := uint64(deflated.Len()+511) / 512
+	l2 := []uint64{
+		3 * testClusterSize,
+		0,
+		qcow2Compressed | (sectors-1)<<54 | 4*testClusterSize,
+		qcow2Zero,
+	}
+	_, err = file.Seek(2*testClusterSize, 0)
+	ok(t, err)
+	ok(t, binary.Write(file, binary.BigEndian, l2))
+
+	_, err = file.WriteAt(standard, 3*testClusterSize)
+	ok(t, err)
+	_, err = file.WriteAt(deflated.Bytes(), 4*testClusterSize)
+	ok(t, err)
+}
+
+// Reads grain i of a monolithic sparse disk, nil if it is not allocated
+func readTestGrain(t *testing.T, file *os.File, header *sparseExtentHeader, i uint64) []byte {
+	gt := make([]byte, 4)
+	_, err := file.ReadAt(gt, int64(header.GDOffset*sectorSize+i/vmdkGTEsPerGT*4))
+	ok(t, err)
+
+	gte := make([]byte, 4)
+	_, err = file.ReadAt(gte, int64(uint64(binary.LittleEndian.Uint32(gt))*sectorSize+i%vmdkGTEsPerGT*4))
+	ok(t, err)
+
+	offset := binary.LittleEndian.Uint32(gte)
+	if offset == 0 {
+		return nil
+	}
+
+	grain := make([]byte, vmdkGrainSize*sectorSize)
+	_, err = file.ReadAt(grain, int64(offset)*sectorSize)
+	ok(t, err)
+	return grain
+}
+
+func TestConvertQCOW2(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "terraform-vix")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+
+	standard := bytes.Repeat([]byte("terraform"), testClusterSize/9+1)[:testClusterSize]
+	compressed := bytes.Repeat([]byte("vix"), testClusterSize/3+1)[:testClusterSize]
+
+	qcow2Path := filepath.Join(dir, "disk.qcow2")
+	writeTestQCOW2(t, qcow2Path, standard, compressed)
+
+	file, err := os.Open(qcow2Path)
+	ok(t, err)
+	defer file.Close()
+
+	img, err := openQCOW2(file)
+	ok(t, err)
+	equals(t, uint64(4*testClusterSize), img.Size())
+
+	path := filepath.Join(dir, "disk.vmdk")
+	ok(t, convertToSparseVMDK(path, img, img.Size(), "lsilogic", 11))
+
+	vmdk, err := os.Open(path)
+	ok(t, err)
+	defer vmdk.Close()
+
+	header := readSparseExtentHeader(t, path)
+	equals(t, uint64(4*testClusterSize/sectorSize), header.Capacity)
+
+	// Only grains holding data are allocated
+	equals(t, standard, readTestGrain(t, vmdk, header, 0))
+	equals(t, []byte(nil), readTestGrain(t, vmdk, header, 1))
+	equals(t, compressed, readTestGrain(t, vmdk, header, 2))
+	equals(t, []byte(nil), readTestGrain(t, vmdk, header, 3))
+
+	info, err := vmdk.Stat()
+	ok(t, err)
+	equals(t, int64((header.OverHead+2*vmdkGrainSize)*sectorSize), info.Size())
+
+	_, err = openQCOW2(bytes.NewReader(standard))
+	assert(t, err != nil, "raw data is not a qcow2 image")
+}
+
+func TestImportDiskImage(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "terraform-vix")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+
+	// Raw images are usually shipped compressed, such as disk.img.gz
+	data := make([]byte, 3*testClusterSize+100)
+	copy(data[testClusterSize:], "boot sector")
+
+	imgPath := filepath.Join(dir, "disk.img.gz")
+	file, err := os.Create(imgPath)
+	ok(t, err)
+	defer file.Close()
+
+	w := gzip.NewWriter(file)
+	_, err = w.Write(data)
+	ok(t, err)
+	ok(t, w.Close())
+
+	v := &VM{Name: "web01", Image: Image{Format: ImageFormatRaw}}
+	goldPath := filepath.Join(dir, "gold")
+	assert(t, v.importDiskImage(file, goldPath) != nil, "guest_os is required")
+
+	v.GuestOS = "ubuntu-64"
+	ok(t, v.importDiskImage(file, goldPath))
+
+	raw, err := readVMX(filepath.Join(goldPath, "image.vmx"))
+	ok(t, err)
+	equals(t, "ubuntu-64", raw["guestos"])
+	equals(t, "disk.vmdk", raw["scsi0:0.filename"])
+
+	header := readSparseExtentHeader(t, filepath.Join(goldPath, "disk.vmdk"))
+	equals(t, uint64(len(data)+511)/sectorSize, header.Capacity)
+
+	files, err := ioutil.ReadDir(dir)
+	ok(t, err)
+	equals(t, 2, len(files))
+
+	// Failed imports leave nothing behind
+	v.Image.Format = ImageFormatQCOW2
+	goldPath = filepath.Join(dir, "gold-qcow2")
+	assert(t, v.importDiskImage(file, goldPath) != nil, "raw data is not a qcow2 image")
+
+	_, err = os.Stat(goldPath)
+	assert(t, os.IsNotExist(err), "%s should have been removed", goldPath)
+}
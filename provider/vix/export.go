@@ -0,0 +1,394 @@
+package vix
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	govix "github.com/hooklift/govix"
+)
+
+// Export formats
+const (
+	ExportFormatBox = "box"
+	ExportFormatOVA = "ova"
+)
+
+// How to keep disks consistent while exporting them
+const (
+	ExportConsistencyPowerOff = "power_off"
+	ExportConsistencySnapshot = "snapshot"
+)
+
+// Prefix of snapshots taken to export running virtual machines
+const exportSnapshotPrefix = "terraform-export-"
+
+// Packaged copy of a virtual machine
+type Export struct {
+	// Either box, a Vagrant box for the vmware_desktop provider, or ova
+	Format string
+	// Path of the package
+	Output string
+	// Either power_off, to export the virtual machine powered off and power
+	// it back on afterwards if it was running, or snapshot, to export the
+	// disks as they were when a temporary snapshot was taken
+	Consistency string
+}
+
+// Disk as written to an exported package
+type exportedDisk struct {
+	*Disk
+	// Name of the vmdk file in the package, its size and the capacity of the
+	// disk, in bytes
+	File     string
+	Size     int64
+	Capacity uint64
+}
+
+// Packages the virtual machine as export.Output, returning the SHA256
+// checksum of the package.
+func (v *VM) Export(vmxFile string, export *Export) (sum string, err error) {
+	client, vm, err := v.open(vmxFile)
+	if err != nil {
+		return "", err
+	}
+	defer client.Disconnect()
+
+	running, err := vm.IsRunning()
+	if err != nil {
+		return "", err
+	}
+
+	var vmx map[string]string
+	if export.Consistency == ExportConsistencySnapshot {
+		// Disks are exported as they were right before the snapshot, VMware
+		// points the VMX file to new delta disks once it is taken.
+		if vmx, err = readVMX(vmxFile); err != nil {
+			return "", err
+		}
+
+		name := exportSnapshotPrefix + time.Now().UTC().Format("20060102T150405Z")
+		log.Printf("[INFO] Taking snapshot %q to export %s...", name, vmxFile)
+		var snapshot *govix.Snapshot
+		snapshot, err = vm.CreateSnapshot(name, "Taken by Terraform to export the virtual machine", 0)
+		if err != nil {
+			return "", err
+		}
+
+		defer func() {
+			log.Printf("[INFO] Removing snapshot %q...", name)
+			if rerr := vm.RemoveSnapshot(snapshot, govix.SNAPSHOT_REMOVE_NONE); rerr != nil && err == nil {
+				err = fmt.Errorf("[ERROR] Unable to remove snapshot %q: %s", name, rerr)
+			}
+		}()
+	} else {
+		if running {
+			if err = v.powerOff(vm); err != nil {
+				return "", err
+			}
+
+			defer func() {
+				options := govix.VMPOWEROP_NORMAL
+				if v.LaunchGUI {
+					options |= govix.VMPOWEROP_LAUNCH_GUI
+				}
+
+				log.Println("[INFO] Powering virtual machine back on...")
+				if perr := vm.PowerOn(options); perr != nil && err == nil {
+					err = perr
+				}
+			}()
+		}
+
+		if vmx, err = readVMX(vmxFile); err != nil {
+			return "", err
+		}
+	}
+
+	return export.write(vmxFile, vmx)
+}
+
+// Writes the package out of vmx, the VMX file of the virtual machine as of
+// the time it is exported.
+func (x *Export) write(vmxFile string, vmx map[string]string) (string, error) {
+	dir := filepath.Dir(x.Output)
+	if err := os.MkdirAll(dir, 0740); err != nil {
+		return "", err
+	}
+
+	// Stages files next to the output, as disks can be large
+	staging, err := ioutil.TempDir(dir, ".vix-export-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(staging)
+
+	var files []string
+	if x.Format == ExportFormatOVA {
+		files, err = stageOVA(vmxFile, vmx, staging)
+	} else {
+		files, err = stageBox(vmxFile, vmx, staging)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	tmp := x.Output + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmp)
+	defer file.Close()
+
+	hasher := sha256.New()
+	w := io.MultiWriter(file, hasher)
+
+	log.Printf("[INFO] Writing %s package %s...", x.Format, x.Output)
+	if x.Format == ExportFormatOVA {
+		err = writeTar(w, staging, files)
+	} else {
+		gz := gzip.NewWriter(w)
+		if err = writeTar(gz, staging, files); err == nil {
+			err = gz.Close()
+		}
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if err = file.Close(); err != nil {
+		return "", err
+	}
+
+	if err = os.Rename(tmp, x.Output); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
+
+// Writes files, relative to dir, to a tar stream in the given order
+func writeTar(w io.Writer, dir string, files []string) error {
+	tw := tar.NewWriter(w)
+
+	for _, name := range files {
+		path := filepath.Join(dir, name)
+		info, err := os.Stat(path)
+		if err != nil {
+			return err
+		}
+
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = name
+
+		if err = tw.WriteHeader(header); err != nil {
+			return err
+		}
+
+		file, err := os.Open(path)
+		if err != nil {
+			return err
+		}
+
+		_, err = io.Copy(tw, file)
+		file.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	return tw.Close()
+}
+
+// Writes the disks of the virtual machine to dir, flattening snapshot chains,
+// either as monolithic sparse or stream optimized disks. Disks are named
+// after the virtual machine and sorted in boot order.
+func stageDisks(vmxFile string, vmx map[string]string, dir string, streamOptimized bool) ([]*exportedDisk, error) {
+	name := strings.TrimSuffix(filepath.Base(vmxFile), filepath.Ext(vmxFile))
+	vmDir := filepath.Dir(vmxFile)
+
+	hwVersion, err := strconv.Atoi(vmx["virtualhw.version"])
+	if err != nil {
+		hwVersion = scratchHardwareVersion
+	}
+
+	disks := vmxDisks(vmx)
+	sortDisks(disks, vmx["bios.hddorder"])
+
+	staged := make([]*exportedDisk, 0, len(disks))
+	for i, disk := range disks {
+		src, err := openVMDK(vmdkPath(vmDir, disk.Path))
+		if err != nil {
+			return nil, err
+		}
+
+		file := fmt.Sprintf("%s-disk%d.vmdk", name, i+1)
+		path := filepath.Join(dir, file)
+
+		log.Printf("[DEBUG] Exporting disk %s: %s", disk.ID(), disk.Path)
+		if streamOptimized {
+			err = writeStreamOptimizedVMDK(path, src, src.Size(), disk.adapterType(), hwVersion)
+		} else {
+			err = convertToSparseVMDK(path, src, src.Size(), disk.adapterType(), hwVersion)
+		}
+		src.Close()
+		if err != nil {
+			return nil, err
+		}
+
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+
+		staged = append(staged, &exportedDisk{Disk: disk, File: file, Size: info.Size(), Capacity: src.Size()})
+	}
+
+	return staged, nil
+}
+
+// Strips the VMX file of settings tied to the host or to this particular
+// virtual machine: CD/DVD and floppy images, shared folders and guestinfo
+// variables. Disks are pointed to their exported copies.
+func exportVMX(vmx map[string]string, disks []*exportedDisk) map[string]string {
+	exported := make(map[string]string, len(vmx))
+	for key, value := range vmx {
+		switch {
+		case strings.HasPrefix(key, "guestinfo."),
+			strings.HasPrefix(key, "sharedfolder"),
+			strings.HasPrefix(key, "floppy0."),
+			key == "extendedconfigfile",
+			key == "sched.swap.derivedname":
+			continue
+		}
+		exported[key] = value
+	}
+	exported["floppy0.present"] = "FALSE"
+
+	for key, value := range vmx {
+		if !strings.HasSuffix(key, ".devicetype") || value != "cdrom-image" {
+			continue
+		}
+
+		id := strings.TrimSuffix(key, ".devicetype")
+		exported[id+".devicetype"] = "cdrom-raw"
+		exported[id+".filename"] = "auto detect"
+		exported[id+".startconnected"] = "FALSE"
+	}
+
+	for _, disk := range disks {
+		exported[disk.ID()+".filename"] = disk.File
+	}
+
+	return exported
+}
+
+// Stages a Vagrant box for the vmware_desktop provider
+func stageBox(vmxFile string, vmx map[string]string, dir string) ([]string, error) {
+	disks, err := stageDisks(vmxFile, vmx, dir, false)
+	if err != nil {
+		return nil, err
+	}
+
+	name := filepath.Base(vmxFile)
+	exported := exportVMX(vmx, disks)
+	files := []string{"metadata.json", name}
+
+	if nvram := vmx["nvram"]; nvram != "" {
+		data, err := ioutil.ReadFile(vmdkPath(filepath.Dir(vmxFile), nvram))
+		if err == nil {
+			exported["nvram"] = filepath.Base(nvram)
+			files = append(files, exported["nvram"])
+			err = ioutil.WriteFile(filepath.Join(dir, exported["nvram"]), data, 0644)
+		}
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+
+	if err = writeVMX(filepath.Join(dir, name), exported); err != nil {
+		return nil, err
+	}
+
+	metadata := []byte(`{"provider": "vmware_desktop"}` + "\n")
+	if err = ioutil.WriteFile(filepath.Join(dir, "metadata.json"), metadata, 0644); err != nil {
+		return nil, err
+	}
+
+	for _, disk := range disks {
+		files = append(files, disk.File)
+	}
+
+	return files, nil
+}
+
+// Stages an OVA package: the OVF descriptor first, then its manifest and
+// disks, as the OVF specification requires.
+func stageOVA(vmxFile string, vmx map[string]string, dir string) ([]string, error) {
+	disks, err := stageDisks(vmxFile, vmx, dir, true)
+	if err != nil {
+		return nil, err
+	}
+
+	name := strings.TrimSuffix(filepath.Base(vmxFile), filepath.Ext(vmxFile))
+	descriptor, err := newOVF(name, vmx, disks)
+	if err != nil {
+		return nil, err
+	}
+
+	ovfFile := name + ".ovf"
+	if err = ioutil.WriteFile(filepath.Join(dir, ovfFile), descriptor, 0644); err != nil {
+		return nil, err
+	}
+
+	files := []string{ovfFile}
+	for _, disk := range disks {
+		files = append(files, disk.File)
+	}
+
+	var manifest strings.Builder
+	for _, file := range files {
+		sum, err := fileSHA256(filepath.Join(dir, file))
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(&manifest, "SHA256(%s)= %s\n", file, sum)
+	}
+
+	mfFile := name + ".mf"
+	if err = ioutil.WriteFile(filepath.Join(dir, mfFile), []byte(manifest.String()), 0644); err != nil {
+		return nil, err
+	}
+
+	return append([]string{ovfFile, mfFile}, files[1:]...), nil
+}
+
+// Computes the SHA256 checksum of a file
+func fileSHA256(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hasher := sha256.New()
+	if _, err = io.Copy(hasher, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hasher.Sum(nil)), nil
+}
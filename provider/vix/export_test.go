@@ -0,0 +1,158 @@
+package vix
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Writes a virtual machine with a single disk, a CD/DVD image and a guestinfo
+// variable, returning its VMX file.
+func writeTestVM(t *testing.T, dir string) (string, map[string]string) {
+	vmxFile := filepath.Join(dir, "web01", "web01.vmx")
+	ok(t, os.MkdirAll(filepath.Dir(vmxFile), 0740))
+
+	data := testDiskData(2*1024*1024, 0)
+	ok(t, convertToSparseVMDK(filepath.Join(dir, "web01", "web01.vmdk"), bytes.NewReader(data),
+		uint64(len(data)), "lsilogic", 11))
+	ok(t, ioutil.WriteFile(filepath.Join(dir, "web01", "web01.nvram"), []byte("nvram"), 0644))
+
+	vmx := map[string]string{
+		"displayname":              "Web 01",
+		"guestos":                  "ubuntu-64",
+		"virtualhw.version":        "11",
+		"memsize":                  "1024",
+		"numvcpus":                 "2",
+		"nvram":                    "web01.nvram",
+		"scsi0.present":            "TRUE",
+		"scsi0.virtualdev":         "pvscsi",
+		"scsi0:0.present":          "TRUE",
+		"scsi0:0.filename":         "web01.vmdk",
+		"ide1:0.present":           "TRUE",
+		"ide1:0.devicetype":        "cdrom-image",
+		"ide1:0.filename":          "/isos/seed.iso",
+		"ethernet0.present":        "TRUE",
+		"ethernet0.connectiontype": "nat",
+		"ethernet0.virtualdev":     "vmxnet3",
+		"guestinfo.token":          "secret",
+	}
+	ok(t, writeVMX(vmxFile, vmx))
+
+	return vmxFile, vmx
+}
+
+// Reads the entries of a tar stream, in order
+func readTar(t *testing.T, r io.Reader) ([]string, map[string][]byte) {
+	var names []string
+	files := make(map[string][]byte)
+
+	tr := tar.NewReader(r)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		ok(t, err)
+
+		data, err := ioutil.ReadAll(tr)
+		ok(t, err)
+
+		names = append(names, header.Name)
+		files[header.Name] = data
+	}
+
+	return names, files
+}
+
+func TestExportBox(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "terraform-vix")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+
+	vmxFile, vmx := writeTestVM(t, dir)
+
+	export := &Export{Format: ExportFormatBox, Output: filepath.Join(dir, "boxes", "web01.box")}
+	sum, err := export.write(vmxFile, vmx)
+	ok(t, err)
+
+	actual, err := fileSHA256(export.Output)
+	ok(t, err)
+	equals(t, actual, sum)
+
+	file, err := os.Open(export.Output)
+	ok(t, err)
+	defer file.Close()
+
+	gz, err := gzip.NewReader(file)
+	ok(t, err)
+
+	names, files := readTar(t, gz)
+	equals(t, []string{"metadata.json", "web01.vmx", "web01.nvram", "web01-disk1.vmdk"}, names)
+	assert(t, strings.Contains(string(files["metadata.json"]), `"vmware_desktop"`), "invalid metadata")
+
+	exportedVMX := filepath.Join(dir, "exported.vmx")
+	ok(t, ioutil.WriteFile(exportedVMX, files["web01.vmx"], 0644))
+	exported, err := readVMX(exportedVMX)
+	ok(t, err)
+	equals(t, "web01-disk1.vmdk", exported["scsi0:0.filename"])
+	equals(t, "cdrom-raw", exported["ide1:0.devicetype"])
+	equals(t, "", exported["guestinfo.token"])
+	equals(t, "1024", exported["memsize"])
+
+	// Staging files are cleaned up
+	entries, err := ioutil.ReadDir(filepath.Dir(export.Output))
+	ok(t, err)
+	equals(t, 1, len(entries))
+}
+
+func TestExportOVA(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "terraform-vix")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+
+	vmxFile, vmx := writeTestVM(t, dir)
+
+	export := &Export{Format: ExportFormatOVA, Output: filepath.Join(dir, "web01.ova")}
+	_, err = export.write(vmxFile, vmx)
+	ok(t, err)
+
+	file, err := os.Open(export.Output)
+	ok(t, err)
+	defer file.Close()
+
+	// The OVF descriptor has to come first
+	names, files := readTar(t, file)
+	equals(t, []string{"web01.ovf", "web01.mf", "web01-disk1.vmdk"}, names)
+
+	sum := sha256.Sum256(files["web01-disk1.vmdk"])
+	manifest := string(files["web01.mf"])
+	assert(t, strings.Contains(manifest, fmt.Sprintf("SHA256(web01-disk1.vmdk)= %x\n", sum)),
+		"invalid manifest: %s", manifest)
+
+	envelope := new(ovfEnvelope)
+	ok(t, xml.Unmarshal(files["web01.ovf"], envelope))
+
+	ovf := string(files["web01.ovf"])
+	for _, expected := range []string{
+		`<vssd:VirtualSystemType>vmx-11</vssd:VirtualSystemType>`,
+		`<rasd:ResourceSubType>VirtualSCSI</rasd:ResourceSubType>`,
+		`<rasd:ResourceSubType>vmxnet3</rasd:ResourceSubType>`,
+		`<rasd:HostResource>ovf:/disk/vmdisk1</rasd:HostResource>`,
+		`ovf:capacity="2097152"`,
+		`<Network ovf:name="nat">`,
+	} {
+		assert(t, strings.Contains(ovf, expected), "%s not found in OVF descriptor:\n%s", expected, ovf)
+	}
+
+	_, err = newOVF("web01", map[string]string{}, nil)
+	assert(t, err != nil, "memsize is required")
+}
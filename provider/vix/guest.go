@@ -0,0 +1,131 @@
+package vix
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+
+	govix "github.com/hooklift/govix"
+)
+
+// Session in the guest operating system, opened through VMware Tools. It
+// allows to operate on guests that are not reachable over the network.
+type GuestSession struct {
+	client *govix.Host
+	vm     *govix.VM
+	guest  *govix.Guest
+}
+
+// Opens the virtual machine and logs into its guest with the VM guest
+// credentials, once VMware Tools is running. The virtual machine has to be
+// powered on.
+func (v *VM) LoginInGuest(vmxFile string) (*GuestSession, error) {
+	v.SetDefaults()
+
+	client, err := v.client()
+	if err != nil {
+		return nil, err
+	}
+
+	vm, err := client.OpenVM(vmxFile, v.Image.Password)
+	if err != nil {
+		client.Disconnect()
+		return nil, err
+	}
+
+	running, err := vm.IsRunning()
+	if err != nil || !running {
+		client.Disconnect()
+		return nil, fmt.Errorf("[ERROR] Virtual machine %s is not running", vmxFile)
+	}
+
+	log.Println("[INFO] Waiting for VMware Tools to initialize...")
+	if err = vm.WaitForToolsInGuest(v.ToolsInitTimeout); err != nil {
+		client.Disconnect()
+		return nil, fmt.Errorf("[ERROR] VMware Tools is not running in %s: %s", vmxFile, err)
+	}
+
+	log.Printf("[DEBUG] Logging into the guest as %s", v.GuestUsername)
+	guest, err := vm.LoginInGuest(v.GuestUsername, v.GuestPassword, govix.LOGIN_IN_GUEST_NONE)
+	if err != nil {
+		client.Disconnect()
+		return nil, fmt.Errorf("[ERROR] Unable to log into the guest: %s", err)
+	}
+
+	return &GuestSession{client: client, vm: vm, guest: guest}, nil
+}
+
+// Copies a file or directory from the host to the guest.
+func (s *GuestSession) CopyFileToGuest(src, dest string) error {
+	log.Printf("[DEBUG] Copying %s to guest's %s", src, dest)
+	return s.client.CopyFileToGuest(src, s.guest, dest)
+}
+
+// Runs a script with the given interpreter, waiting for it to exit.
+func (s *GuestSession) RunScript(interpreter, script string) (uint64, int, error) {
+	pid, _, exitCode, err := s.guest.RunScript(interpreter, script, govix.RUNPROGRAM_WAIT)
+	return pid, exitCode, err
+}
+
+// Largest script output read back from the guest
+const maxScriptOutput = 1024 * 1024
+
+// Runs a script like RunScript, also returning what it wrote to stdout and
+// stderr. VIX does not hand output over, so the script is uploaded and run
+// with its output redirected to a guest file, which is downloaded once the
+// script exits. Only guests with a POSIX shell are supported.
+func (s *GuestSession) RunScriptWithOutput(interpreter, script string) (uint64, int, string, error) {
+	suffix := make([]byte, 8)
+	if _, err := rand.Read(suffix); err != nil {
+		return 0, 0, "", err
+	}
+	base := "/tmp/terraform-vix-" + hex.EncodeToString(suffix)
+	scriptPath, outputPath := base+".script", base+".out"
+
+	tmp, err := ioutil.TempFile("", "terraform-vix")
+	if err != nil {
+		return 0, 0, "", err
+	}
+	defer os.Remove(tmp.Name())
+
+	_, err = tmp.WriteString(script)
+	tmp.Close()
+	if err != nil {
+		return 0, 0, "", err
+	}
+
+	if err = s.CopyFileToGuest(tmp.Name(), scriptPath); err != nil {
+		return 0, 0, "", err
+	}
+	defer s.RunProgram("/bin/rm", "-f "+scriptPath+" "+outputPath)
+
+	pid, exitCode, err := s.RunScript("/bin/sh", fmt.Sprintf("%s %s > %s 2>&1",
+		interpreter, shellQuote(scriptPath), shellQuote(outputPath)))
+	if err != nil {
+		return pid, exitCode, "", err
+	}
+
+	output, err := s.ReadFile(outputPath, maxScriptOutput)
+	if err != nil {
+		log.Printf("[WARN] Unable to read the output of pid %d: %s", pid, err)
+	}
+
+	return pid, exitCode, string(output), nil
+}
+
+// Runs a program with the given arguments, waiting for it to exit.
+func (s *GuestSession) RunProgram(path, args string) (uint64, int, error) {
+	pid, _, exitCode, err := s.guest.RunProgram(path, args, govix.RUNPROGRAM_WAIT)
+	return pid, exitCode, err
+}
+
+// Logs out from the guest and disconnects from VMware.
+func (s *GuestSession) Close() {
+	if err := s.guest.Logout(); err != nil {
+		log.Printf("[WARN] Unable to log out from the guest: %s", err)
+	}
+	s.client.Disconnect()
+}
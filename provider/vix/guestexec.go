@@ -0,0 +1,84 @@
+package vix
+
+import (
+	"fmt"
+	"log"
+
+	govix "github.com/hooklift/govix"
+)
+
+// When guest commands run
+const (
+	// Only once, when the virtual machine is created
+	GuestExecOnCreate = "create"
+	// Every time the virtual machine is updated
+	GuestExecOnUpdate = "update"
+)
+
+// Script to run inside the guest through VMware Tools
+type GuestExec struct {
+	// Guest credentials, the VM guest credentials are used if empty
+	Username string
+	Password string
+	// Path to the script interpreter in the guest, ie: /bin/sh
+	Interpreter string
+	// Text of the script
+	Script string
+	// Either GuestExecOnCreate or GuestExecOnUpdate
+	When string
+	// Process ID and exit code of the last run
+	PID      uint64
+	ExitCode int
+}
+
+// Runs the scripts meant for the current operation, in order, stopping at the
+// first one exiting with a non-zero code. VMware Tools has to be running.
+func (v *VM) runGuestExecs(vm *govix.VM) error {
+	for i, exec := range v.GuestExecs {
+		if exec.When != v.operation() {
+			continue
+		}
+
+		username, password := exec.Username, exec.Password
+		if username == "" {
+			username, password = v.GuestUsername, v.GuestPassword
+		}
+
+		log.Printf("[INFO] Running guest_exec %d as %s...", i, username)
+		guest, err := vm.LoginInGuest(username, password, govix.LOGIN_IN_GUEST_NONE)
+		if err != nil {
+			return fmt.Errorf("[ERROR] Unable to log into the guest to run guest_exec %d: %s", i, err)
+		}
+
+		exec.PID, _, exec.ExitCode, err = guest.RunScript(exec.Interpreter, exec.Script, govix.RUNPROGRAM_WAIT)
+		guest.Logout()
+		if err != nil {
+			return fmt.Errorf("[ERROR] Unable to run guest_exec %d: %s", i, err)
+		}
+
+		log.Printf("[DEBUG] guest_exec %d, pid: %d, exit code: %d", i, exec.PID, exec.ExitCode)
+		if exec.ExitCode != 0 {
+			return fmt.Errorf("[ERROR] guest_exec %d exited with code %d", i, exec.ExitCode)
+		}
+	}
+
+	return nil
+}
+
+// Whether there are scripts to run for the current operation
+func (v *VM) pendingGuestExecs() bool {
+	for _, exec := range v.GuestExecs {
+		if exec.When == v.operation() {
+			return true
+		}
+	}
+	return false
+}
+
+// Operation in progress, either GuestExecOnCreate or GuestExecOnUpdate
+func (v *VM) operation() string {
+	if v.creating {
+		return GuestExecOnCreate
+	}
+	return GuestExecOnUpdate
+}
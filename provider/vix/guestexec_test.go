@@ -0,0 +1,15 @@
+package vix
+
+import "testing"
+
+func TestPendingGuestExecs(t *testing.T) {
+	v := &VM{
+		GuestExecs: []*GuestExec{
+			&GuestExec{Script: "echo hi", When: GuestExecOnCreate},
+		},
+	}
+	equals(t, false, v.pendingGuestExecs())
+
+	v.creating = true
+	equals(t, true, v.pendingGuestExecs())
+}
@@ -0,0 +1,216 @@
+package vix
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+
+	govix "github.com/hooklift/govix"
+)
+
+// File uploaded to the guest through VMware Tools
+type GuestFile struct {
+	// Local file to upload, used if Content is empty
+	Source string
+	// Literal content to upload
+	Content string
+	// Absolute path in the guest
+	Destination string
+	// Octal permissions to set in the guest, ie: 0644. Not supported on Windows.
+	Mode string
+	// Checksum of the last upload, the file is uploaded again if it changes
+	Checksum string
+}
+
+// Computes the checksum of the file content and mode.
+func (f *GuestFile) CurrentChecksum() (string, error) {
+	hash := sha256.New()
+
+	if f.Content != "" || f.Source == "" {
+		io.WriteString(hash, f.Content)
+	} else {
+		file, err := os.Open(f.Source)
+		if err != nil {
+			return "", err
+		}
+		defer file.Close()
+
+		if _, err = io.Copy(hash, file); err != nil {
+			return "", err
+		}
+	}
+
+	io.WriteString(hash, "\x00"+f.Destination+"\x00"+f.Mode)
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// Uploads the file to the guest, unless it did not change since the last
+// upload.
+func (s *GuestSession) uploadFile(f *GuestFile) error {
+	checksum, err := f.CurrentChecksum()
+	if err != nil {
+		return err
+	}
+
+	if checksum == f.Checksum {
+		log.Printf("[DEBUG] %s did not change, skipping upload", f.Destination)
+		return nil
+	}
+
+	src := f.Source
+	if f.Content != "" || f.Source == "" {
+		tmp, err := ioutil.TempFile("", "terraform-vix")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(tmp.Name())
+
+		_, err = tmp.WriteString(f.Content)
+		tmp.Close()
+		if err != nil {
+			return err
+		}
+		src = tmp.Name()
+	}
+
+	if err = s.CopyFileToGuest(src, f.Destination); err != nil {
+		return err
+	}
+
+	if f.Mode != "" && isWindowsPath(f.Destination) {
+		log.Printf("[WARN] Unable to set mode of %s, Windows guests are not supported.", f.Destination)
+	} else if f.Mode != "" {
+		pid, exitCode, err := s.RunProgram("/bin/chmod", f.Mode+" "+f.Destination)
+		if err != nil {
+			return err
+		}
+		if exitCode != 0 {
+			return fmt.Errorf("[ERROR] chmod %s %s (pid %d) exited with code %d",
+				f.Mode, f.Destination, pid, exitCode)
+		}
+	}
+
+	f.Checksum = checksum
+
+	return nil
+}
+
+// Uploads guest files that changed since the last upload. VMware Tools has to
+// be running.
+func (v *VM) uploadGuestFiles(vm *govix.VM, client *govix.Host) error {
+	if len(v.GuestFiles) == 0 {
+		return nil
+	}
+
+	guest, err := vm.LoginInGuest(v.GuestUsername, v.GuestPassword, govix.LOGIN_IN_GUEST_NONE)
+	if err != nil {
+		return fmt.Errorf("[ERROR] Unable to log into the guest to upload files: %s", err)
+	}
+
+	session := &GuestSession{client: client, vm: vm, guest: guest}
+	defer guest.Logout()
+
+	for _, file := range v.GuestFiles {
+		log.Printf("[INFO] Uploading guest file %s...", file.Destination)
+		if err = session.uploadFile(file); err != nil {
+			return fmt.Errorf("[ERROR] Unable to upload %s: %s", file.Destination, err)
+		}
+	}
+
+	return nil
+}
+
+// Reads a file from the guest, failing if it is larger than maxSize bytes.
+// The size is checked in the guest first, so large files are not copied over.
+func (s *GuestSession) ReadFile(path string, maxSize int64) ([]byte, error) {
+	interpreter, script := fileSizeCheckScript(path, maxSize)
+	pid, exitCode, err := s.RunScript(interpreter, script)
+	if err != nil {
+		return nil, err
+	}
+	if exitCode == 1 {
+		return nil, fmt.Errorf("[ERROR] %s is larger than %d bytes", path, maxSize)
+	}
+	if exitCode != 0 {
+		log.Printf("[WARN] Unable to find out the size of %s (pid %d, exit code %d), copying it anyway", path, pid, exitCode)
+	}
+
+	tmp, err := ioutil.TempDir("", "terraform-vix")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	hostpath := tmp + string(os.PathSeparator) + "file"
+
+	log.Printf("[DEBUG] Copying guest's %s to %s", path, hostpath)
+	if err = s.guest.CopyFileToHost(path, hostpath); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(hostpath)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	data, err := ioutil.ReadAll(io.LimitReader(file, maxSize+1))
+	if err != nil {
+		return nil, err
+	}
+
+	if int64(len(data)) > maxSize {
+		return nil, fmt.Errorf("[ERROR] %s is larger than %d bytes", path, maxSize)
+	}
+
+	return data, nil
+}
+
+// Builds a script exiting with code 1 if the guest file is larger than
+// maxSize bytes. GoVIX's FileInfo and Ls do not return file sizes, they hand
+// VIX nil pointers, so the guest itself is asked instead.
+func fileSizeCheckScript(path string, maxSize int64) (string, string) {
+	if isWindowsPath(path) {
+		return "", fmt.Sprintf(`@for %%%%I in ("%s") do @if %%%%~zI GTR %d exit 1`, path, maxSize)
+	}
+
+	return "/bin/sh", fmt.Sprintf(`size=$(wc -c < %s) || exit 2; [ "$size" -le %d ] || exit 1`, shellQuote(path), maxSize)
+}
+
+// Quotes a string for POSIX shells
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
+
+// Whether a guest path looks like a Windows one, where chmod makes no sense
+func isWindowsPath(path string) bool {
+	return len(path) > 1 && path[1] == ':' || strings.HasPrefix(path, `\\`)
+}
+
+// Combines the checksums of the last uploads, or the current ones, of all
+// the files. It is empty if there are no files.
+func GuestFilesChecksum(files []*GuestFile, current bool) (string, error) {
+	if len(files) == 0 {
+		return "", nil
+	}
+
+	hash := sha256.New()
+	for _, file := range files {
+		checksum := file.Checksum
+		if current {
+			var err error
+			if checksum, err = file.CurrentChecksum(); err != nil {
+				return "", err
+			}
+		}
+		io.WriteString(hash, checksum+"\n")
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
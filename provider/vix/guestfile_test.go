@@ -0,0 +1,57 @@
+package vix
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGuestFileChecksum(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "terraform-vix")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+
+	source := filepath.Join(dir, "motd")
+	ok(t, ioutil.WriteFile(source, []byte("hello\n"), 0644))
+
+	fromSource := &GuestFile{Source: source, Destination: "/etc/motd"}
+	fromContent := &GuestFile{Content: "hello\n", Destination: "/etc/motd"}
+
+	checksum, err := fromSource.CurrentChecksum()
+	ok(t, err)
+	other, err := fromContent.CurrentChecksum()
+	ok(t, err)
+	equals(t, checksum, other)
+
+	fromContent.Mode = "0600"
+	other, err = fromContent.CurrentChecksum()
+	ok(t, err)
+	assert(t, checksum != other, "mode changes should change the checksum")
+
+	ok(t, ioutil.WriteFile(source, []byte("bye\n"), 0644))
+	other, err = fromSource.CurrentChecksum()
+	ok(t, err)
+	assert(t, checksum != other, "source changes should change the checksum")
+
+	all, err := GuestFilesChecksum(nil, true)
+	ok(t, err)
+	equals(t, "", all)
+
+	fromSource.Checksum = checksum
+	uploaded, err := GuestFilesChecksum([]*GuestFile{fromSource}, false)
+	ok(t, err)
+	current, err := GuestFilesChecksum([]*GuestFile{fromSource}, true)
+	ok(t, err)
+	assert(t, uploaded != current, "changed files should change the combined checksum")
+}
+
+func TestFileSizeCheckScript(t *testing.T) {
+	interpreter, script := fileSizeCheckScript("/etc/it's", 1024)
+	equals(t, "/bin/sh", interpreter)
+	equals(t, `size=$(wc -c < '/etc/it'\''s') || exit 2; [ "$size" -le 1024 ] || exit 1`, script)
+
+	interpreter, script = fileSizeCheckScript(`C:\Windows\setup.log`, 1024)
+	equals(t, "", interpreter)
+	equals(t, `@for %%I in ("C:\Windows\setup.log") do @if %%~zI GTR 1024 exit 1`, script)
+}
@@ -0,0 +1,88 @@
+package vix
+
+import (
+	"encoding/base64"
+	"log"
+	"strings"
+)
+
+// Prefix of VMX keys guests are able to read through VMware Tools, ie:
+// vmtoolsd --cmd "info-get guestinfo.userdata"
+const guestInfoPrefix = "guestinfo."
+
+// Suffix of the VMX key telling cloud-init and Ignition how a guestinfo
+// variable is encoded.
+const guestInfoEncodingSuffix = ".encoding"
+
+// Reads guestinfo variables out of the VMX file, indexed by their name without
+// prefix. Base64 encoded values are decoded.
+func readGuestInfo(vmx map[string]string) map[string]string {
+	vars := make(map[string]string)
+	for key, value := range vmx {
+		if !strings.HasPrefix(key, guestInfoPrefix) ||
+			strings.HasSuffix(key, guestInfoEncodingSuffix) {
+			continue
+		}
+
+		if vmx[key+guestInfoEncodingSuffix] == "base64" {
+			decoded, err := base64.StdEncoding.DecodeString(value)
+			if err != nil {
+				log.Printf("[WARN] Unable to decode %s: %s", key, err)
+				continue
+			}
+			value = string(decoded)
+		}
+
+		vars[strings.TrimPrefix(key, guestInfoPrefix)] = value
+	}
+
+	return vars
+}
+
+// Writes v.GuestInfo to the VMX file and removes the variables in
+// v.StaleGuestInfo, along with their encoding keys. Values are base64 encoded
+// so they survive VMX quoting, along with the encoding key cloud-init and
+// Ignition expect. Variables with a nil value are carried over from current,
+// the VMX file as it was before updating it, and so are variables not managed
+// by Terraform. The virtual machine has to be powered off.
+func (v *VM) writeGuestInfo(vmxFile string, current map[string]string) error {
+	vars := readGuestInfo(current)
+
+	return updateVMX(vmxFile, func(vmx map[string]string) error {
+		// GoVMX drops guestinfo keys when rewriting the VMX file
+		for key, value := range current {
+			if _, ok := vmx[key]; !ok && strings.HasPrefix(key, guestInfoPrefix) {
+				vmx[key] = value
+			}
+		}
+
+		for _, name := range v.StaleGuestInfo {
+			name = strings.ToLower(name)
+
+			log.Printf("[DEBUG] Removing guestinfo.%s", name)
+			key := guestInfoPrefix + name
+			delete(vmx, key)
+			delete(vmx, key+guestInfoEncodingSuffix)
+		}
+
+		for name, value := range v.GuestInfo {
+			name = strings.ToLower(name)
+
+			if value == nil {
+				known, ok := vars[name]
+				if !ok {
+					log.Printf("[WARN] guestinfo.%s is no longer in the VMX file and its value is unknown, skipping it.", name)
+					continue
+				}
+				value = &known
+			}
+
+			log.Printf("[DEBUG] Setting guestinfo.%s", name)
+			key := guestInfoPrefix + name
+			vmx[key] = base64.StdEncoding.EncodeToString([]byte(*value))
+			vmx[key+guestInfoEncodingSuffix] = "base64"
+		}
+
+		return nil
+	})
+}
@@ -0,0 +1,56 @@
+package vix
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestGuestInfo(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "terraform-vix")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+
+	vmxFile := filepath.Join(dir, "core01.vmx")
+	ok(t, ioutil.WriteFile(vmxFile, []byte(`displayName = "core01"
+guestinfo.hostname = "core01"
+guestinfo.userdata = "I2Nsb3VkLWNvbmZpZwo="
+guestinfo.userdata.encoding = "base64"
+guestinfo.stale = "bye"
+`), 0644))
+
+	current, err := readVMX(vmxFile)
+	ok(t, err)
+	equals(t, map[string]string{
+		"hostname": "core01",
+		"userdata": "#cloud-config\n",
+		"stale":    "bye",
+	}, readGuestInfo(current))
+
+	// GoVMX drops guestinfo keys when rewriting the VMX file
+	ok(t, writeVMX(vmxFile, map[string]string{"displayname": "core01"}))
+
+	metadata := "instance-id: core01\n"
+	vm := &VM{
+		GuestInfo: map[string]*string{
+			"userdata": nil,
+			"metadata": &metadata,
+			"missing":  nil,
+		},
+		StaleGuestInfo: []string{"stale"},
+	}
+	ok(t, vm.writeGuestInfo(vmxFile, current))
+
+	// Variables not managed by Terraform are left alone
+	vmx, err := readVMX(vmxFile)
+	ok(t, err)
+	equals(t, map[string]string{
+		"displayname":                 "core01",
+		"guestinfo.hostname":          "core01",
+		"guestinfo.userdata":          "I2Nsb3VkLWNvbmZpZwo=",
+		"guestinfo.userdata.encoding": "base64",
+		"guestinfo.metadata":          "aW5zdGFuY2UtaWQ6IGNvcmUwMQo=",
+		"guestinfo.metadata.encoding": "base64",
+	}, vmx)
+}
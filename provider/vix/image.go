@@ -27,6 +27,9 @@ type Image struct {
 	Checksum string
 	// Algorithm use to check the checksum
 	ChecksumType string
+	// Either qcow2 or raw for plain disk images, which are converted to VMDK.
+	// Images are expected to be packaged virtual machines otherwise.
+	Format string
 	// Password to decrypt the virtual machine if it is encrypted. This is used by
 	// VIX to be able to open the virtual machine
 	Password string
@@ -37,15 +40,15 @@ type Image struct {
 // Downloads and a virtual machine image
 func (img *Image) Download(destPath string) error {
 	if img.URL == "" {
-		panic("URL is required")
+		return fmt.Errorf("[ERROR] Image URL is required")
 	}
 
 	if img.Checksum == "" {
-		panic("Checksum is required")
+		return fmt.Errorf("[ERROR] Image checksum is required")
 	}
 
 	if img.ChecksumType == "" {
-		panic("Checksum type is required")
+		return fmt.Errorf("[ERROR] Image checksum type is required")
 	}
 
 	if destPath == "" {
@@ -46,3 +46,14 @@ func TestDownload(t *testing.T) {
 	size := finfo.Size()
 	assert(t, size > 0, fmt.Sprintf("Image file is empty: %d", size))
 }
+
+func TestDownloadRequiredFields(t *testing.T) {
+	for _, image := range []Image{
+		{Checksum: "35fd19dc1bb7e18a365c1c589df2292942c197a4", ChecksumType: "sha1"},
+		{URL: "http://example.com/test.box", ChecksumType: "sha1"},
+		{URL: "http://example.com/test.box", Checksum: "35fd19dc1bb7e18a365c1c589df2292942c197a4"},
+	} {
+		err := image.Download(os.TempDir())
+		assert(t, err != nil, "Download of %+v should fail", image)
+	}
+}
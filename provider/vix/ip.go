@@ -0,0 +1,169 @@
+package vix
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"time"
+
+	govix "github.com/hooklift/govix"
+)
+
+// Interval between guest IP address lookups
+var ipPollInterval = 5 * time.Second
+
+// Strategy to wait for the guest to get a usable IP address
+type WaitForIP struct {
+	// How long to wait before giving up
+	Timeout time.Duration
+	// Network adapter whose address to wait for. VMware Tools only reports the
+	// address of the guest's primary interface, addresses of other adapters
+	// are looked up in VMware's DHCP leases.
+	NetworkAdapterIndex int
+	// Addresses within these networks are not considered usable
+	ExcludeCIDRs []*net.IPNet
+	// Whether to wait for an IPv6 address instead of an IPv4 one
+	IPv6 bool
+}
+
+// Checks whether an address is routable and not excluded.
+func (w *WaitForIP) usable(ip net.IP) bool {
+	if ip == nil || ip.IsUnspecified() || ip.IsLoopback() ||
+		ip.IsLinkLocalUnicast() || ip.IsMulticast() {
+		return false
+	}
+
+	if isIPv4 := ip.To4() != nil; isIPv4 == w.IPv6 {
+		return false
+	}
+
+	for _, cidr := range w.ExcludeCIDRs {
+		if cidr.Contains(ip) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Returns the first usable address out of the candidates, or an empty string.
+func (w *WaitForIP) pick(candidates []string) string {
+	for _, candidate := range candidates {
+		if w.usable(net.ParseIP(candidate)) {
+			return candidate
+		}
+	}
+	return ""
+}
+
+// Looks up the guest IP address candidates for the network adapter we are
+// interested in.
+func (v *VM) guestIPCandidates(vm *govix.VM) []string {
+	var candidates []string
+
+	index := 0
+	if v.WaitForIP != nil {
+		index = v.WaitForIP.NetworkAdapterIndex
+	}
+
+	if index == 0 {
+		if ip, err := vm.IPAddress(); err == nil && ip != "" {
+			candidates = append(candidates, ip)
+		}
+	}
+
+	// Falls back to DHCP leases for guests without VMware Tools running
+	if ip := v.adapterLeasedIP(vm, index); ip != "" {
+		candidates = append(candidates, ip)
+	}
+
+	return candidates
+}
+
+// Looks up the address leased by VMware's DHCP server to a network adapter.
+func (v *VM) adapterLeasedIP(vm *govix.VM, index int) string {
+	vmxFile, err := vm.VmxPath()
+	if err != nil {
+		return ""
+	}
+
+	vmx, err := readVMX(vmxFile)
+	if err != nil {
+		return ""
+	}
+
+	vmnet, mac := adapterLeaseKey(vmx, index)
+	if vmnet == "" || mac == nil {
+		return ""
+	}
+
+	return leasedIP(vmnet, mac)
+}
+
+// Finds out the vmnet serving the network adapter and its MAC address, out of
+// the VMX file. Only NAT, host-only and custom networks are served by VMware's
+// DHCP server, an empty vmnet is returned otherwise.
+func adapterLeaseKey(vmx map[string]string, index int) (string, net.HardwareAddr) {
+	adapters, vswitches := networkAdapters(vmx)
+	if index >= len(adapters) {
+		return "", nil
+	}
+	adapter := adapters[index]
+
+	var vmnet string
+	switch adapter.ConnType {
+	case govix.NETWORK_NAT:
+		vmnet = "vmnet8"
+	case govix.NETWORK_HOSTONLY:
+		vmnet = "vmnet1"
+	case govix.NETWORK_CUSTOM:
+		vmnet = vswitches[index]
+	}
+
+	mac := adapter.MacAddress
+	if mac == nil {
+		mac = adapter.GeneratedMacAddress
+	}
+
+	return vmnet, mac
+}
+
+// Returns the guest IP address. If a wait strategy is configured, only
+// usable addresses are returned.
+func (v *VM) guestIP(vm *govix.VM) (string, error) {
+	candidates := v.guestIPCandidates(vm)
+
+	if v.WaitForIP == nil {
+		if len(candidates) == 0 {
+			return "", nil
+		}
+		return candidates[0], nil
+	}
+
+	return v.WaitForIP.pick(candidates), nil
+}
+
+// Polls the guest until it gets a usable IP address or the timeout expires.
+func (v *VM) waitForIP(vm *govix.VM) (string, error) {
+	deadline := time.Now().Add(v.WaitForIP.Timeout)
+
+	for {
+		ip, err := v.guestIP(vm)
+		if err != nil {
+			return "", err
+		}
+
+		if ip != "" {
+			log.Printf("[INFO] Guest IP address: %s", ip)
+			return ip, nil
+		}
+
+		if time.Now().After(deadline) {
+			return "", fmt.Errorf("[ERROR] Timed out after %s waiting for network adapter %d of %s to get a usable IP address. "+
+				"Make sure VMware Tools is running in the guest and the address is not excluded by exclude_cidrs",
+				v.WaitForIP.Timeout, v.WaitForIP.NetworkAdapterIndex, v.Name)
+		}
+
+		time.Sleep(ipPollInterval)
+	}
+}
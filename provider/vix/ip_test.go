@@ -0,0 +1,43 @@
+package vix
+
+import (
+	"net"
+	"testing"
+)
+
+func TestWaitForIPPick(t *testing.T) {
+	_, excluded, err := net.ParseCIDR("172.17.0.0/16")
+	ok(t, err)
+
+	w := &WaitForIP{ExcludeCIDRs: []*net.IPNet{excluded}}
+	equals(t, "", w.pick(nil))
+	equals(t, "", w.pick([]string{"127.0.0.1", "169.254.10.1", "0.0.0.0", "172.17.0.2", "garbage"}))
+	equals(t, "192.168.10.5", w.pick([]string{"fe80::1", "2001:db8::5", "192.168.10.5"}))
+
+	w.IPv6 = true
+	equals(t, "2001:db8::5", w.pick([]string{"fe80::1", "192.168.10.5", "2001:db8::5"}))
+}
+
+func TestAdapterLeaseKey(t *testing.T) {
+	// Keys as written by VMware, once the provider rewrote the VMX file
+	vmx, err := readVMX("./fixtures/network.vmx")
+	ok(t, err)
+	vmx["ethernet1.connectiontype"] = "custom"
+	vmx["ethernet1.vnet"] = "/dev/vmnet2"
+
+	vmnet, mac := adapterLeaseKey(vmx, 0)
+	equals(t, "vmnet8", vmnet)
+	equals(t, "00:0c:29:1a:2b:3c", mac.String())
+
+	vmnet, mac = adapterLeaseKey(vmx, 1)
+	equals(t, "vmnet2", vmnet)
+	equals(t, "00:50:56:00:00:01", mac.String())
+
+	vmx["ethernet0.connectiontype"] = "bridged"
+	vmnet, _ = adapterLeaseKey(vmx, 0)
+	equals(t, "", vmnet)
+
+	vmnet, mac = adapterLeaseKey(vmx, 2)
+	equals(t, "", vmnet)
+	assert(t, mac == nil, "there is no third adapter")
+}
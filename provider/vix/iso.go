@@ -0,0 +1,264 @@
+package vix
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+// ISO 9660 logical sector size
+const isoSectorSize = 2048
+
+// Sectors preceding file contents in images written by writeISO: the system
+// area, primary and Joliet volume descriptors, the set terminator, four path
+// tables and the two root directories.
+const isoDataSector = 16 + 3 + 4 + 2
+
+// Writes an ISO 9660 image holding files in its root directory. File names
+// are recorded through Joliet extensions, so guests get them as they are
+// instead of being mangled into 8.3 names. Directories are not supported, it
+// is only meant to build seed images such as the ones used by cloud-init.
+func writeISO(path, label string, files map[string][]byte) error {
+	names := make([]string, 0, len(files))
+	for name := range files {
+		if strings.ContainsAny(name, `/\`) {
+			return fmt.Errorf("[ERROR] Directories are not supported in ISO images: %s", name)
+		}
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	// Files are laid out one after another, each one starting in a new sector
+	extents := make(map[string]uint32, len(names))
+	sector := uint32(isoDataSector)
+	for _, name := range names {
+		extents[name] = sector
+		sector += isoSectors(len(files[name]))
+	}
+	totalSectors := sector
+
+	now := time.Now().UTC()
+	primaryRoot, jolietRoot := uint32(23), uint32(24)
+
+	// The primary hierarchy uses ISO 9660 level 1 names, ie: USER_DAT.;1
+	var primaryDir bytes.Buffer
+	primaryDir.Write(isoDirRecord([]byte{0}, primaryRoot, isoSectorSize, true, now))
+	primaryDir.Write(isoDirRecord([]byte{1}, primaryRoot, isoSectorSize, true, now))
+	shortNames := make(map[string]string, len(names))
+	for _, name := range names {
+		shortNames[isoShortName(name, shortNames)] = name
+	}
+	for _, short := range sortedKeys(shortNames) {
+		name := shortNames[short]
+		primaryDir.Write(isoDirRecord([]byte(short), extents[name], len(files[name]), false, now))
+	}
+
+	var jolietDir bytes.Buffer
+	jolietDir.Write(isoDirRecord([]byte{0}, jolietRoot, isoSectorSize, true, now))
+	jolietDir.Write(isoDirRecord([]byte{1}, jolietRoot, isoSectorSize, true, now))
+	for _, name := range names {
+		jolietDir.Write(isoDirRecord(ucs2(name), extents[name], len(files[name]), false, now))
+	}
+
+	if primaryDir.Len() > isoSectorSize || jolietDir.Len() > isoSectorSize {
+		return fmt.Errorf("[ERROR] Too many files for an ISO image root directory")
+	}
+
+	image := make([]byte, int(totalSectors)*isoSectorSize)
+	at := func(sector uint32) []byte {
+		return image[int(sector)*isoSectorSize:]
+	}
+
+	pathTableSize := copy(at(19), isoPathTable(primaryRoot, binary.LittleEndian))
+	copy(at(20), isoPathTable(primaryRoot, binary.BigEndian))
+	copy(at(21), isoPathTable(jolietRoot, binary.LittleEndian))
+	copy(at(22), isoPathTable(jolietRoot, binary.BigEndian))
+
+	copy(at(16), isoVolumeDescriptor(1, label, totalSectors, uint32(pathTableSize), 19, 20,
+		isoDirRecord([]byte{0}, primaryRoot, isoSectorSize, true, now), now))
+	copy(at(17), isoVolumeDescriptor(2, label, totalSectors, uint32(pathTableSize), 21, 22,
+		isoDirRecord([]byte{0}, jolietRoot, isoSectorSize, true, now), now))
+
+	terminator := at(18)
+	terminator[0] = 255
+	copy(terminator[1:], "CD001")
+	terminator[6] = 1
+
+	copy(at(primaryRoot), primaryDir.Bytes())
+	copy(at(jolietRoot), jolietDir.Bytes())
+
+	for _, name := range names {
+		copy(at(extents[name]), files[name])
+	}
+
+	return ioutil.WriteFile(path, image, 0644)
+}
+
+// Number of sectors needed to hold size bytes
+func isoSectors(size int) uint32 {
+	return uint32((size + isoSectorSize - 1) / isoSectorSize)
+}
+
+// Builds a unique ISO 9660 level 1 file identifier out of name.
+func isoShortName(name string, taken map[string]string) string {
+	clean := func(s string, max int) string {
+		s = strings.Map(func(r rune) rune {
+			switch {
+			case r >= 'A' && r <= 'Z', r >= '0' && r <= '9', r == '_':
+				return r
+			case r >= 'a' && r <= 'z':
+				return r - 'a' + 'A'
+			default:
+				return '_'
+			}
+		}, s)
+		if len(s) > max {
+			s = s[:max]
+		}
+		return s
+	}
+
+	base, ext := name, ""
+	if i := strings.LastIndex(name, "."); i > 0 {
+		base, ext = name[:i], name[i+1:]
+	}
+	base, ext = clean(base, 8), clean(ext, 3)
+
+	short := base + "." + ext + ";1"
+	for i := 1; ; i++ {
+		if _, ok := taken[short]; !ok {
+			return short
+		}
+		suffix := fmt.Sprintf("%d", i)
+		if len(base)+len(suffix) > 8 {
+			base = base[:8-len(suffix)]
+		}
+		short = base + suffix + "." + ext + ";1"
+	}
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Encodes s as UCS-2 big endian, as required by Joliet.
+func ucs2(s string) []byte {
+	codes := utf16.Encode([]rune(s))
+	b := make([]byte, len(codes)*2)
+	for i, c := range codes {
+		binary.BigEndian.PutUint16(b[i*2:], c)
+	}
+	return b
+}
+
+// Writes v in both byte orders, as many ISO 9660 fields require.
+func bothEndian32(b []byte, v uint32) {
+	binary.LittleEndian.PutUint32(b, v)
+	binary.BigEndian.PutUint32(b[4:], v)
+}
+
+func bothEndian16(b []byte, v uint16) {
+	binary.LittleEndian.PutUint16(b, v)
+	binary.BigEndian.PutUint16(b[2:], v)
+}
+
+// Builds a directory record. The identifier is padded so records always have
+// an even length.
+func isoDirRecord(id []byte, extent uint32, size int, dir bool, t time.Time) []byte {
+	length := 33 + len(id)
+	if length%2 != 0 {
+		length++
+	}
+
+	r := make([]byte, length)
+	r[0] = byte(length)
+	bothEndian32(r[2:], extent)
+	bothEndian32(r[10:], uint32(size))
+	r[18] = byte(t.Year() - 1900)
+	r[19] = byte(t.Month())
+	r[20] = byte(t.Day())
+	r[21] = byte(t.Hour())
+	r[22] = byte(t.Minute())
+	r[23] = byte(t.Second())
+	if dir {
+		r[25] = 2
+	}
+	bothEndian16(r[28:], 1)
+	r[32] = byte(len(id))
+	copy(r[33:], id)
+
+	return r
+}
+
+// Builds a path table holding only the root directory.
+func isoPathTable(root uint32, order binary.ByteOrder) []byte {
+	t := make([]byte, 10)
+	t[0] = 1
+	order.PutUint32(t[2:], root)
+	order.PutUint16(t[6:], 1)
+	return t
+}
+
+// Builds a primary (1) or Joliet supplementary (2) volume descriptor.
+func isoVolumeDescriptor(kind byte, label string, sectors, pathTableSize, lTable, mTable uint32, root []byte, t time.Time) []byte {
+	d := make([]byte, isoSectorSize)
+	d[0] = kind
+	copy(d[1:], "CD001")
+	d[6] = 1
+
+	text := func(offset, length int, s string) {
+		field := d[offset : offset+length]
+		if kind == 2 {
+			for i := 0; i+1 < length; i += 2 {
+				field[i], field[i+1] = 0, ' '
+			}
+			copy(field, ucs2(s))
+			return
+		}
+		for i := range field {
+			field[i] = ' '
+		}
+		copy(field, s)
+	}
+
+	text(8, 32, "")
+	text(40, 32, label)
+	bothEndian32(d[80:], sectors)
+	if kind == 2 {
+		// UCS-2 level 3
+		copy(d[88:], "%/E")
+	}
+	bothEndian16(d[120:], 1)
+	bothEndian16(d[124:], 1)
+	bothEndian16(d[128:], isoSectorSize)
+	bothEndian32(d[132:], pathTableSize)
+	binary.LittleEndian.PutUint32(d[140:], lTable)
+	binary.BigEndian.PutUint32(d[148:], mTable)
+	copy(d[156:], root)
+	text(190, 128, "")
+	text(318, 128, "")
+	text(446, 128, "")
+	text(574, 128, "TERRAFORM-PROVIDER-VIX")
+	text(702, 37, "")
+	text(739, 37, "")
+	text(776, 37, "")
+
+	stamp := []byte(t.Format("20060102150405") + "00")
+	copy(d[813:], stamp)
+	copy(d[830:], stamp)
+	copy(d[847:], "0000000000000000")
+	copy(d[864:], "0000000000000000")
+	d[881] = 1
+
+	return d
+}
@@ -0,0 +1,80 @@
+package vix
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteISO(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "terraform-vix")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+
+	files := map[string][]byte{
+		"user-data":      []byte("#cloud-config\n"),
+		"meta-data":      []byte("instance-id: core01\n"),
+		"network-config": bytes.Repeat([]byte("x"), isoSectorSize+1),
+	}
+
+	path := filepath.Join(dir, "seed.iso")
+	ok(t, writeISO(path, "cidata", files))
+
+	image, err := ioutil.ReadFile(path)
+	ok(t, err)
+	equals(t, 0, len(image)%isoSectorSize)
+
+	sector := func(n uint32) []byte {
+		return image[int(n)*isoSectorSize : int(n+1)*isoSectorSize]
+	}
+
+	primary := sector(16)
+	equals(t, byte(1), primary[0])
+	equals(t, "CD001", string(primary[1:6]))
+	equals(t, "cidata", strings.TrimRight(string(primary[40:72]), " "))
+	equals(t, uint32(len(image)/isoSectorSize), binary.LittleEndian.Uint32(primary[80:]))
+
+	joliet := sector(17)
+	equals(t, byte(2), joliet[0])
+	equals(t, "%/E", string(joliet[88:91]))
+	equals(t, ucs2("cidata"), joliet[40:52])
+	equals(t, byte(255), sector(18)[0])
+
+	// Walks the Joliet root directory, skipping "." and ".."
+	root := sector(binary.LittleEndian.Uint32(joliet[156+2:]))
+	found := make(map[string][]byte)
+	for offset := 0; root[offset] != 0; offset += int(root[offset]) {
+		record := root[offset:]
+		id := record[33 : 33+int(record[32])]
+		if len(id) == 1 {
+			continue
+		}
+
+		name := make([]rune, 0, len(id)/2)
+		for i := 0; i < len(id); i += 2 {
+			name = append(name, rune(binary.BigEndian.Uint16(id[i:])))
+		}
+
+		extent := binary.LittleEndian.Uint32(record[2:])
+		size := binary.LittleEndian.Uint32(record[10:])
+		start := int(extent) * isoSectorSize
+		found[string(name)] = image[start : start+int(size)]
+	}
+
+	equals(t, files, found)
+}
+
+func TestISOShortName(t *testing.T) {
+	taken := make(map[string]string)
+
+	short := isoShortName("user-data", taken)
+	equals(t, "USER_DAT.;1", short)
+	taken[short] = "user-data"
+
+	equals(t, "USER_DA1.;1", isoShortName("user-data2", taken))
+	equals(t, "CIDATA.ISO;1", isoShortName("cidata.iso", taken))
+}
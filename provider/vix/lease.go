@@ -0,0 +1,161 @@
+package vix
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// DHCP lease handed out by VMware's DHCP server
+type Lease struct {
+	IP           net.IP
+	MAC          net.HardwareAddr
+	Starts       time.Time
+	Ends         time.Time
+	BindingState string
+	Hostname     string
+}
+
+// Checks whether the lease is in use at the given time.
+func (l *Lease) active(now time.Time) bool {
+	switch l.BindingState {
+	case "", "active":
+	default:
+		return false
+	}
+	return l.Ends.IsZero() || l.Ends.After(now)
+}
+
+// Parses a dhcpd.leases file as written by VMware's ISC based DHCP server.
+// Unknown statements are ignored.
+func parseLeases(r io.Reader) ([]*Lease, error) {
+	var leases []*Lease
+	var lease *Lease
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		if lease == nil {
+			fields := strings.Fields(line)
+			if len(fields) >= 2 && fields[0] == "lease" {
+				lease = &Lease{IP: net.ParseIP(fields[1])}
+			}
+			continue
+		}
+
+		if line == "}" {
+			if lease.IP != nil {
+				leases = append(leases, lease)
+			}
+			lease = nil
+			continue
+		}
+
+		fields := strings.Fields(strings.TrimSuffix(line, ";"))
+		if len(fields) < 2 {
+			continue
+		}
+
+		switch fields[0] {
+		case "starts":
+			lease.Starts = parseLeaseTime(fields[1:])
+		case "ends":
+			lease.Ends = parseLeaseTime(fields[1:])
+		case "hardware":
+			if len(fields) == 3 && fields[1] == "ethernet" {
+				lease.MAC, _ = net.ParseMAC(fields[2])
+			}
+		case "binding":
+			if len(fields) == 3 && fields[1] == "state" {
+				lease.BindingState = fields[2]
+			}
+		case "client-hostname":
+			lease.Hostname = strings.Trim(fields[1], `"`)
+		}
+	}
+
+	return leases, scanner.Err()
+}
+
+// Parses lease times in the form "<weekday> 2016/01/21 19:15:58", which are in
+// UTC. "never" and unparsable times return the zero time.
+func parseLeaseTime(fields []string) time.Time {
+	if len(fields) < 3 {
+		return time.Time{}
+	}
+
+	t, err := time.Parse("2006/01/02 15:04:05", fields[1]+" "+fields[2])
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// Returns the most recent active lease for the given MAC address, or nil.
+func latestLease(leases []*Lease, mac net.HardwareAddr, now time.Time) *Lease {
+	var latest *Lease
+	for _, lease := range leases {
+		if lease.MAC.String() != mac.String() || !lease.active(now) {
+			continue
+		}
+
+		if latest == nil || !lease.Starts.Before(latest.Starts) {
+			latest = lease
+		}
+	}
+	return latest
+}
+
+// Returns the lease files where VMware's DHCP server keeps the leases of the
+// given vmnet.
+func leaseFiles(vmnet string) []string {
+	switch runtime.GOOS {
+	case "darwin":
+		return []string{
+			filepath.Join("/var/db/vmware", "vmnet-dhcpd-"+vmnet+".leases"),
+		}
+	case "windows":
+		return []string{
+			filepath.Join(os.Getenv("ProgramData"), "VMware", "vmnetdhcp.leases"),
+			filepath.Join(os.Getenv("ALLUSERSPROFILE"), "Application Data", "VMware", "vmnetdhcp.leases"),
+		}
+	default:
+		return []string{
+			filepath.Join("/etc/vmware", vmnet, "dhcpd", "dhcpd.leases"),
+		}
+	}
+}
+
+// Looks up the IP address VMware's DHCP server leased to the given MAC
+// address on a vmnet. An empty string is returned if there is none.
+func leasedIP(vmnet string, mac net.HardwareAddr) string {
+	now := time.Now().UTC()
+
+	for _, path := range leaseFiles(vmnet) {
+		file, err := os.Open(path)
+		if err != nil {
+			continue
+		}
+
+		leases, err := parseLeases(file)
+		file.Close()
+		if err != nil {
+			continue
+		}
+
+		if lease := latestLease(leases, mac, now); lease != nil {
+			return lease.IP.String()
+		}
+	}
+
+	return ""
+}
@@ -0,0 +1,67 @@
+package vix
+
+import (
+	"net"
+	"os"
+	"testing"
+	"time"
+)
+
+func loadLeases(t *testing.T, path string) []*Lease {
+	file, err := os.Open(path)
+	ok(t, err)
+	defer file.Close()
+
+	leases, err := parseLeases(file)
+	ok(t, err)
+	return leases
+}
+
+func TestParseLeases(t *testing.T) {
+	leases := loadLeases(t, "./fixtures/dhcpd.leases")
+	equals(t, 4, len(leases))
+
+	lease := leases[0]
+	equals(t, "172.16.21.128", lease.IP.String())
+	equals(t, "00:0c:29:d2:f6:4b", lease.MAC.String())
+	equals(t, "core01", lease.Hostname)
+	equals(t, time.Date(2016, 1, 21, 19, 15, 58, 0, time.UTC), lease.Starts)
+	equals(t, time.Date(2016, 1, 21, 19, 45, 58, 0, time.UTC), lease.Ends)
+
+	equals(t, "free", leases[2].BindingState)
+	assert(t, leases[3].Ends.IsZero(), "lease ending never should have a zero end time")
+}
+
+func TestLatestLease(t *testing.T) {
+	leases := loadLeases(t, "./fixtures/dhcpd.leases")
+	now := time.Date(2016, 1, 22, 10, 10, 0, 0, time.UTC)
+
+	mac, _ := net.ParseMAC("00:0c:29:d2:f6:4b")
+	lease := latestLease(leases, mac, now)
+	assert(t, lease != nil, "no active lease found for %s", mac)
+	equals(t, "172.16.21.131", lease.IP.String())
+
+	// Free leases are skipped
+	mac, _ = net.ParseMAC("00:50:56:2a:bb:cc")
+	lease = latestLease(leases, mac, now)
+	assert(t, lease != nil, "no active lease found for %s", mac)
+	equals(t, "172.16.21.130", lease.IP.String())
+
+	// Expired leases are skipped
+	mac, _ = net.ParseMAC("00:0c:29:d2:f6:4b")
+	assert(t, latestLease(leases, mac, now.Add(time.Hour)) == nil, "expired lease was returned")
+
+	mac, _ = net.ParseMAC("00:0c:29:00:00:01")
+	assert(t, latestLease(leases, mac, now) == nil, "lease was returned for an unknown MAC address")
+}
+
+func TestLatestLeaseWindows(t *testing.T) {
+	leases := loadLeases(t, "./fixtures/vmnetdhcp.leases")
+	now := time.Date(2017, 7, 3, 18, 30, 0, 0, time.UTC)
+
+	mac, _ := net.ParseMAC("00:0c:29:1a:2b:3c")
+	lease := latestLease(leases, mac, now)
+	assert(t, lease != nil, "no active lease found for %s", mac)
+	equals(t, "192.168.56.131", lease.IP.String())
+	equals(t, "WIN-BUILD01", lease.Hostname)
+}
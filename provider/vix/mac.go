@@ -0,0 +1,146 @@
+package vix
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"net"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	govix "github.com/hooklift/govix"
+)
+
+// MAC address type for addresses derived from the vmx path and adapter index.
+// They are attached to VMware as static addresses.
+const MACAddressTypeAutoStatic govix.MacAddressType = "auto_static"
+
+// VMware's OUI for manually assigned MAC addresses. Only the lower part of its
+// range, 00:50:56:00:00:00 to 00:50:56:3F:FF:FF, is meant for static addresses.
+var vmwareOUI = []byte{0x00, 0x50, 0x56}
+
+// Checks whether a MAC address is within the range VMware reserves for static
+// MAC addresses.
+func IsStaticMAC(mac net.HardwareAddr) bool {
+	return len(mac) == 6 && bytes.Equal(mac[:3], vmwareOUI) && mac[3] <= 0x3f
+}
+
+// Parses a static MAC address, making sure it is within VMware's static range.
+func ParseStaticMAC(s string) (net.HardwareAddr, error) {
+	mac, err := net.ParseMAC(s)
+	if err != nil {
+		return nil, err
+	}
+
+	if !IsStaticMAC(mac) {
+		return nil, fmt.Errorf("MAC address %s is outside VMware's static range 00:50:56:00:00:00 - 00:50:56:3F:FF:FF", s)
+	}
+
+	return mac, nil
+}
+
+// Derives a MAC address within VMware's static range out of the vmx path and
+// the adapter index, so the same adapter always gets the same address. If the
+// address is taken, it keeps hashing until it finds a free one.
+func deriveStaticMAC(vmxFile string, index int, taken map[string]bool) (net.HardwareAddr, error) {
+	seed := []byte(vmxFile + "#" + strconv.Itoa(index))
+
+	// The static range has 2^22 addresses, giving up after 1024 attempts is
+	// more than enough to detect an exhausted or corrupted list.
+	for i := 0; i < 1024; i++ {
+		sum := sha1.Sum(seed)
+		suffix := binary.BigEndian.Uint32(sum[:4]) & 0x3fffff
+
+		mac := net.HardwareAddr{
+			vmwareOUI[0], vmwareOUI[1], vmwareOUI[2],
+			byte(suffix >> 16), byte(suffix >> 8), byte(suffix),
+		}
+
+		if !taken[mac.String()] {
+			return mac, nil
+		}
+		seed = sum[:]
+	}
+
+	return nil, fmt.Errorf("[ERROR] Unable to find a free static MAC address for adapter %d of %s", index, vmxFile)
+}
+
+// Collects the MAC addresses used by the given vmx files, skipping the one
+// passed in exclude.
+func usedMACs(vmxFiles []string, exclude string) (map[string]bool, error) {
+	taken := make(map[string]bool)
+
+	for _, file := range vmxFiles {
+		if filepath.Clean(file) == filepath.Clean(exclude) {
+			continue
+		}
+
+		vmx, err := readVMX(file)
+		if err != nil {
+			return nil, err
+		}
+
+		for key, value := range vmx {
+			if !strings.HasPrefix(key, "ethernet") ||
+				!(strings.HasSuffix(key, ".address") || strings.HasSuffix(key, ".generatedaddress")) {
+				continue
+			}
+
+			if mac, err := net.ParseMAC(value); err == nil {
+				taken[mac.String()] = true
+			}
+		}
+	}
+
+	return taken, nil
+}
+
+// Assigns MAC addresses to adapters declared as auto_static that do not have
+// one yet, checking for collisions against every virtual machine managed by
+// this provider. Auto static adapters are attached as static ones.
+func (v *VM) assignAutoStaticMACs(vmxFile string) error {
+	var taken map[string]bool
+
+	for i, adapter := range v.VNetworkAdapters {
+		if adapter.MacAddrType != MACAddressTypeAutoStatic {
+			continue
+		}
+		adapter.MacAddrType = govix.NETWORK_MACADDRESSTYPE_STATIC
+
+		// Addresses assigned in previous runs are kept as they are
+		if adapter.MacAddress != nil {
+			continue
+		}
+
+		if taken == nil {
+			files, err := managedVMXFiles()
+			if err != nil {
+				return err
+			}
+
+			if taken, err = usedMACs(files, vmxFile); err != nil {
+				return err
+			}
+
+			for _, a := range v.VNetworkAdapters {
+				if a.MacAddress != nil {
+					taken[a.MacAddress.String()] = true
+				}
+			}
+		}
+
+		mac, err := deriveStaticMAC(vmxFile, i, taken)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("[DEBUG] Assigning static MAC address %s to adapter %d", mac, i)
+		adapter.MacAddress = mac
+		taken[mac.String()] = true
+	}
+
+	return nil
+}
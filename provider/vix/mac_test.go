@@ -0,0 +1,63 @@
+package vix
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestParseStaticMAC(t *testing.T) {
+	for _, mac := range []string{"00:50:56:00:00:00", "00:50:56:3f:ff:ff", "00:50:56:12:34:56"} {
+		_, err := ParseStaticMAC(mac)
+		ok(t, err)
+	}
+
+	for _, mac := range []string{"", "00:50:56:40:00:00", "00:0c:29:00:00:01", "00:50:56:aa:bb:cc", "not-a-mac"} {
+		_, err := ParseStaticMAC(mac)
+		assert(t, err != nil, "%q should not be a valid static MAC address", mac)
+	}
+}
+
+func TestDeriveStaticMAC(t *testing.T) {
+	taken := make(map[string]bool)
+
+	mac, err := deriveStaticMAC("/vms/core01/core01.vmx", 0, taken)
+	ok(t, err)
+	assert(t, IsStaticMAC(mac), "%s is outside VMware's static range", mac)
+
+	again, err := deriveStaticMAC("/vms/core01/core01.vmx", 0, taken)
+	ok(t, err)
+	equals(t, mac, again)
+
+	other, err := deriveStaticMAC("/vms/core01/core01.vmx", 1, taken)
+	ok(t, err)
+	assert(t, mac.String() != other.String(), "adapters got the same address %s", mac)
+
+	taken[mac.String()] = true
+	next, err := deriveStaticMAC("/vms/core01/core01.vmx", 0, taken)
+	ok(t, err)
+	assert(t, IsStaticMAC(next), "%s is outside VMware's static range", next)
+	assert(t, mac.String() != next.String(), "taken address %s was assigned again", mac)
+}
+
+func TestUsedMACs(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "terraform-vix")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+
+	vm1 := filepath.Join(dir, "vm1.vmx")
+	vm2 := filepath.Join(dir, "vm2.vmx")
+	ok(t, ioutil.WriteFile(vm1, []byte(`ethernet0.address = "00:50:56:01:02:03"
+ethernet1.generatedAddress = "00:0C:29:AA:BB:CC"
+`), 0644))
+	ok(t, ioutil.WriteFile(vm2, []byte(`ethernet0.address = "00:50:56:0a:0b:0c"
+`), 0644))
+
+	taken, err := usedMACs([]string{vm1, vm2}, vm2)
+	ok(t, err)
+	equals(t, map[string]bool{
+		"00:50:56:01:02:03": true,
+		"00:0c:29:aa:bb:cc": true,
+	}, taken)
+}
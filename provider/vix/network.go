@@ -0,0 +1,90 @@
+package vix
+
+import (
+	"net"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	govix "github.com/hooklift/govix"
+)
+
+var ethernetKeyRegexp = regexp.MustCompile(`^ethernet(\d+)\.`)
+
+// Reads the network adapters out of the VMX file, ordered by their ethernetN
+// index, along with the virtual switches they are plugged into. Adapters that
+// are not present are skipped.
+//
+// GoVIX is not used since it looks keys up case-sensitively and starts at
+// ethernet1, missing adapters written by VMware or by this provider.
+func networkAdapters(vmx map[string]string) ([]*govix.NetworkAdapter, []string) {
+	indexes := make(map[int]bool)
+	for key := range vmx {
+		if m := ethernetKeyRegexp.FindStringSubmatch(key); m != nil {
+			index, _ := strconv.Atoi(m[1])
+			indexes[index] = true
+		}
+	}
+
+	sorted := make([]int, 0, len(indexes))
+	for index := range indexes {
+		sorted = append(sorted, index)
+	}
+	sort.Ints(sorted)
+
+	var adapters []*govix.NetworkAdapter
+	var vswitches []string
+	for _, index := range sorted {
+		id := strconv.Itoa(index)
+		prefix := "ethernet" + id + "."
+
+		if !strings.EqualFold(vmx[prefix+"present"], "true") {
+			continue
+		}
+
+		// VMware connects adapters on boot unless told otherwise
+		startConnected := true
+		if value, ok := vmx[prefix+"startconnected"]; ok {
+			startConnected = strings.EqualFold(value, "true")
+		}
+
+		address, _ := net.ParseMAC(vmx[prefix+"address"])
+		generated, _ := net.ParseMAC(vmx[prefix+"generatedaddress"])
+
+		adapters = append(adapters, &govix.NetworkAdapter{
+			ID:                        id,
+			ConnType:                  govix.NetworkType(strings.ToLower(vmx[prefix+"connectiontype"])),
+			Vdevice:                   govix.VNetDevice(strings.ToLower(vmx[prefix+"virtualdev"])),
+			WakeOnPcktRcv:             strings.EqualFold(vmx[prefix+"wakeonpcktrcv"], "true"),
+			LinkStatePropagation:      strings.EqualFold(vmx[prefix+"linkstatepropagation.enable"], "true"),
+			MacAddrType:               govix.MacAddressType(strings.ToLower(vmx[prefix+"addresstype"])),
+			MacAddress:                address,
+			StartConnected:            startConnected,
+			GeneratedMacAddress:       generated,
+			GeneratedMacAddressOffset: vmx[prefix+"generatedaddressoffset"],
+			PciSlotNumber:             vmx[prefix+"pcislotnumber"],
+		})
+
+		var vswitch string
+		if vnet := vmx[prefix+"vnet"]; vnet != "" {
+			vswitch = filepath.Base(vnet)
+		}
+		vswitches = append(vswitches, vswitch)
+	}
+
+	return adapters, vswitches
+}
+
+// Reads the network adapters and the virtual switches they are plugged into.
+func (v *VM) readNetworkAdapters(vmxFile string) error {
+	vmx, err := readVMX(vmxFile)
+	if err != nil {
+		return err
+	}
+
+	v.VNetworkAdapters, v.VSwitches = networkAdapters(vmx)
+
+	return nil
+}
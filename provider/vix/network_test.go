@@ -0,0 +1,67 @@
+package vix
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	govix "github.com/hooklift/govix"
+)
+
+func TestNetworkAdaptersRoundTrip(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "terraform-vix")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+
+	data, err := ioutil.ReadFile("./fixtures/network.vmx")
+	ok(t, err)
+
+	vmxFile := filepath.Join(dir, "core01.vmx")
+	ok(t, ioutil.WriteFile(vmxFile, data, 0644))
+
+	// Plugs the second adapter into a custom vswitch, as attachVSwitches does
+	ok(t, updateVMX(vmxFile, func(vmx map[string]string) error {
+		vmx["ethernet1.connectiontype"] = string(govix.NETWORK_CUSTOM)
+		vmx["ethernet1.vnet"] = "/dev/vmnet2"
+		return nil
+	}))
+
+	// GoVIX looks these keys up case-sensitively
+	data, err = ioutil.ReadFile(vmxFile)
+	ok(t, err)
+	assert(t, strings.Contains(string(data), `ethernet1.connectionType = "custom"`), "key spelling was not kept:\n%s", data)
+	assert(t, strings.Contains(string(data), `ethernet0.generatedAddress = "00:0c:29:1a:2b:3c"`), "key spelling was not kept:\n%s", data)
+	assert(t, !strings.Contains(string(data), "ethernet1.connectiontype"), "duplicated key:\n%s", data)
+
+	vm := &VM{}
+	ok(t, vm.readNetworkAdapters(vmxFile))
+
+	generated, _ := net.ParseMAC("00:0c:29:1a:2b:3c")
+	static, _ := net.ParseMAC("00:50:56:00:00:01")
+	equals(t, []*govix.NetworkAdapter{
+		{
+			ID:                        "0",
+			ConnType:                  govix.NETWORK_NAT,
+			Vdevice:                   govix.NETWORK_DEVICE_E1000,
+			MacAddrType:               govix.NETWORK_MACADDRESSTYPE_GENERATED,
+			StartConnected:            true,
+			GeneratedMacAddress:       generated,
+			GeneratedMacAddressOffset: "0",
+			PciSlotNumber:             "33",
+		},
+		{
+			ID:             "1",
+			ConnType:       govix.NETWORK_CUSTOM,
+			Vdevice:        govix.NETWORK_DEVICE_VMXNET3,
+			WakeOnPcktRcv:  true,
+			MacAddrType:    govix.NETWORK_MACADDRESSTYPE_STATIC,
+			MacAddress:     static,
+			StartConnected: false,
+			PciSlotNumber:  "192",
+		},
+	}, vm.VNetworkAdapters)
+	equals(t, []string{"", "vmnet2"}, vm.VSwitches)
+}
@@ -0,0 +1,284 @@
+package vix
+
+import (
+	"encoding/xml"
+	"fmt"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Format of the disks in OVF packages
+const ovfDiskFormat = "http://www.vmware.com/interfaces/specifications/vmdk.html#streamOptimized"
+
+// CIM resource types of virtual hardware items
+const (
+	ovfResourceCPU             = 3
+	ovfResourceMemory          = 4
+	ovfResourceIDEController   = 5
+	ovfResourceSCSIController  = 6
+	ovfResourceEthernetAdapter = 10
+	ovfResourceDisk            = 17
+	ovfResourceOtherController = 20
+)
+
+// OVF 1.0 envelope, only the sections needed to describe a single virtual
+// machine are modeled.
+type ovfEnvelope struct {
+	XMLName    xml.Name `xml:"Envelope"`
+	Xmlns      string   `xml:"xmlns,attr"`
+	XmlnsOVF   string   `xml:"xmlns:ovf,attr"`
+	XmlnsRASD  string   `xml:"xmlns:rasd,attr"`
+	XmlnsVSSD  string   `xml:"xmlns:vssd,attr"`
+	XmlnsXSI   string   `xml:"xmlns:xsi,attr"`
+	References struct {
+		Files []ovfFile `xml:"File"`
+	} `xml:"References"`
+	DiskSection struct {
+		Info  string    `xml:"Info"`
+		Disks []ovfDisk `xml:"Disk"`
+	} `xml:"DiskSection"`
+	NetworkSection struct {
+		Info     string       `xml:"Info"`
+		Networks []ovfNetwork `xml:"Network"`
+	} `xml:"NetworkSection"`
+	VirtualSystem ovfVirtualSystem `xml:"VirtualSystem"`
+}
+
+type ovfFile struct {
+	ID   string `xml:"ovf:id,attr"`
+	Href string `xml:"ovf:href,attr"`
+	Size int64  `xml:"ovf:size,attr"`
+}
+
+type ovfDisk struct {
+	DiskID   string `xml:"ovf:diskId,attr"`
+	FileRef  string `xml:"ovf:fileRef,attr"`
+	Capacity uint64 `xml:"ovf:capacity,attr"`
+	Format   string `xml:"ovf:format,attr"`
+}
+
+type ovfNetwork struct {
+	Name        string `xml:"ovf:name,attr"`
+	Description string `xml:"Description"`
+}
+
+type ovfVirtualSystem struct {
+	ID                     string `xml:"ovf:id,attr"`
+	Info                   string `xml:"Info"`
+	Name                   string `xml:"Name"`
+	OperatingSystemSection struct {
+		ID          int    `xml:"ovf:id,attr"`
+		Info        string `xml:"Info"`
+		Description string `xml:"Description"`
+	} `xml:"OperatingSystemSection"`
+	VirtualHardwareSection struct {
+		Info   string `xml:"Info"`
+		System struct {
+			ElementName             string `xml:"vssd:ElementName"`
+			InstanceID              int    `xml:"vssd:InstanceID"`
+			VirtualSystemIdentifier string `xml:"vssd:VirtualSystemIdentifier"`
+			VirtualSystemType       string `xml:"vssd:VirtualSystemType"`
+		} `xml:"System"`
+		Items []ovfItem `xml:"Item"`
+	} `xml:"VirtualHardwareSection"`
+}
+
+// Virtual hardware item. CIM requires elements to be sorted by name.
+type ovfItem struct {
+	AddressOnParent     string `xml:"rasd:AddressOnParent,omitempty"`
+	AllocationUnits     string `xml:"rasd:AllocationUnits,omitempty"`
+	AutomaticAllocation string `xml:"rasd:AutomaticAllocation,omitempty"`
+	Connection          string `xml:"rasd:Connection,omitempty"`
+	ElementName         string `xml:"rasd:ElementName"`
+	HostResource        string `xml:"rasd:HostResource,omitempty"`
+	InstanceID          int    `xml:"rasd:InstanceID"`
+	Parent              string `xml:"rasd:Parent,omitempty"`
+	ResourceSubType     string `xml:"rasd:ResourceSubType,omitempty"`
+	ResourceType        int    `xml:"rasd:ResourceType"`
+	VirtualQuantity     string `xml:"rasd:VirtualQuantity,omitempty"`
+}
+
+// ResourceSubType of SCSI controllers, as named by VMware in OVF packages
+var ovfSCSISubTypes = map[string]string{
+	"lsilogic":   "lsilogic",
+	"lsisas1068": "lsilogicsas",
+	"pvscsi":     "VirtualSCSI",
+	"buslogic":   "buslogic",
+}
+
+var ethernetPresentRegexp = regexp.MustCompile(`^ethernet(\d+)\.present$`)
+
+// Builds the OVF descriptor of a virtual machine out of its VMX file and the
+// disks written to the package.
+func newOVF(name string, vmx map[string]string, disks []*exportedDisk) ([]byte, error) {
+	env := &ovfEnvelope{
+		Xmlns:     "http://schemas.dmtf.org/ovf/envelope/1",
+		XmlnsOVF:  "http://schemas.dmtf.org/ovf/envelope/1",
+		XmlnsRASD: "http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_ResourceAllocationSettingData",
+		XmlnsVSSD: "http://schemas.dmtf.org/wbem/wscim/1/cim-schema/2/CIM_VirtualSystemSettingData",
+		XmlnsXSI:  "http://www.w3.org/2001/XMLSchema-instance",
+	}
+	env.DiskSection.Info = "Virtual disk information"
+	env.NetworkSection.Info = "The list of logical networks"
+
+	vs := &env.VirtualSystem
+	vs.ID = name
+	vs.Info = "A virtual machine"
+	vs.Name = name
+	if displayName := vmx["displayname"]; displayName != "" {
+		vs.Name = displayName
+	}
+
+	// Other operating system, the guest OS is kept as description
+	vs.OperatingSystemSection.ID = 1
+	vs.OperatingSystemSection.Info = "The kind of installed guest operating system"
+	vs.OperatingSystemSection.Description = vmx["guestos"]
+
+	hw := &vs.VirtualHardwareSection
+	hw.Info = "Virtual hardware requirements"
+	hw.System.ElementName = "Virtual Hardware Family"
+	hw.System.VirtualSystemIdentifier = name
+	hwVersion := vmx["virtualhw.version"]
+	if hwVersion == "" {
+		hwVersion = strconv.Itoa(scratchHardwareVersion)
+	}
+	hw.System.VirtualSystemType = "vmx-" + hwVersion
+
+	instanceID := 0
+	add := func(item ovfItem) int {
+		instanceID++
+		item.InstanceID = instanceID
+		hw.Items = append(hw.Items, item)
+		return instanceID
+	}
+
+	cpus := vmx["numvcpus"]
+	if cpus == "" {
+		cpus = "1"
+	}
+	add(ovfItem{
+		AllocationUnits: "hertz * 10^6",
+		ElementName:     cpus + " virtual CPU(s)",
+		ResourceType:    ovfResourceCPU,
+		VirtualQuantity: cpus,
+	})
+
+	memory := vmx["memsize"]
+	if memory == "" {
+		return nil, fmt.Errorf("[ERROR] memsize is not set in the VMX file")
+	}
+	add(ovfItem{
+		AllocationUnits: "byte * 2^20",
+		ElementName:     memory + "MB of memory",
+		ResourceType:    ovfResourceMemory,
+		VirtualQuantity: memory,
+	})
+
+	controllers := make(map[string]int)
+	for i, disk := range disks {
+		controller := disk.controllerID()
+		if _, ok := controllers[controller]; !ok {
+			item := ovfItem{
+				AddressOnParent: strconv.Itoa(disk.Controller),
+				ElementName:     controller,
+			}
+
+			switch disk.Bus {
+			case "ide":
+				item.ResourceType = ovfResourceIDEController
+			case "scsi":
+				item.ResourceType = ovfResourceSCSIController
+				item.ResourceSubType = ovfSCSISubTypes[vmx[controller+".virtualdev"]]
+				if item.ResourceSubType == "" {
+					item.ResourceSubType = "lsilogic"
+				}
+			case "sata":
+				item.ResourceType = ovfResourceOtherController
+				item.ResourceSubType = "vmware.sata.ahci"
+			case "nvme":
+				item.ResourceType = ovfResourceOtherController
+				item.ResourceSubType = "vmware.nvme.controller"
+			}
+
+			controllers[controller] = add(item)
+		}
+
+		diskID := fmt.Sprintf("vmdisk%d", i+1)
+		fileID := fmt.Sprintf("file%d", i+1)
+
+		env.References.Files = append(env.References.Files, ovfFile{
+			ID:   fileID,
+			Href: disk.File,
+			Size: disk.Size,
+		})
+		env.DiskSection.Disks = append(env.DiskSection.Disks, ovfDisk{
+			DiskID:   diskID,
+			FileRef:  fileID,
+			Capacity: disk.Capacity,
+			Format:   ovfDiskFormat,
+		})
+
+		add(ovfItem{
+			AddressOnParent: strconv.Itoa(disk.Unit),
+			ElementName:     disk.ID(),
+			HostResource:    "ovf:/disk/" + diskID,
+			Parent:          strconv.Itoa(controllers[controller]),
+			ResourceType:    ovfResourceDisk,
+		})
+	}
+
+	var adapters []int
+	for key, present := range vmx {
+		m := ethernetPresentRegexp.FindStringSubmatch(key)
+		if m == nil || !strings.EqualFold(present, "true") {
+			continue
+		}
+		index, _ := strconv.Atoi(m[1])
+		adapters = append(adapters, index)
+	}
+	sort.Ints(adapters)
+
+	networks := make(map[string]bool)
+	for _, index := range adapters {
+		prefix := fmt.Sprintf("ethernet%d.", index)
+
+		network := vmx[prefix+"connectiontype"]
+		if network == "custom" {
+			network = vmx[prefix+"vnet"]
+		}
+		if network == "" {
+			network = "bridged"
+		}
+
+		if !networks[network] {
+			networks[network] = true
+			env.NetworkSection.Networks = append(env.NetworkSection.Networks, ovfNetwork{
+				Name:        network,
+				Description: "The " + network + " network",
+			})
+		}
+
+		adapterType := vmx[prefix+"virtualdev"]
+		if adapterType == "" {
+			adapterType = "vlance"
+		}
+
+		add(ovfItem{
+			AddressOnParent:     strconv.Itoa(index),
+			AutomaticAllocation: "true",
+			Connection:          network,
+			ElementName:         "ethernet" + strconv.Itoa(index),
+			ResourceSubType:     adapterType,
+			ResourceType:        ovfResourceEthernetAdapter,
+		})
+	}
+
+	data, err := xml.MarshalIndent(env, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), data...), nil
+}
@@ -0,0 +1,203 @@
+package vix
+
+import (
+	"bytes"
+	"compress/flate"
+	"encoding/binary"
+	"fmt"
+	"io"
+)
+
+// QEMU copy-on-write image format, as described in docs/interop/qcow2.txt of
+// the QEMU source tree. Offsets are in bytes.
+const (
+	qcow2Magic = 0x514649fb // QFI\xfb
+	// Bits of L1 and L2 entries holding the offset of tables and clusters
+	qcow2OffsetMask = 0x00fffffffffffe00
+	// Flags of L2 entries
+	qcow2Compressed = 1 << 62
+	qcow2Zero       = 1
+	// Incompatible features: dirty refcounts are harmless when reading
+	qcow2FeatureDirty = 1
+)
+
+// Header at the beginning of qcow2 images, fields up to refcount_order are
+// only present in version 3 images.
+type qcow2Header struct {
+	Magic                 uint32
+	Version               uint32
+	BackingFileOffset     uint64
+	BackingFileSize       uint32
+	ClusterBits           uint32
+	Size                  uint64
+	CryptMethod           uint32
+	L1Size                uint32
+	L1TableOffset         uint64
+	RefcountTableOffset   uint64
+	RefcountTableClusters uint32
+	NbSnapshots           uint32
+	SnapshotsOffset       uint64
+	IncompatibleFeatures  uint64
+}
+
+// Reads the guest data of a qcow2 image. Images backed by other images, as
+// well as encrypted ones, are not supported.
+type qcow2Image struct {
+	file        io.ReaderAt
+	header      *qcow2Header
+	clusterSize uint64
+	l1          []uint64
+	// Last L2 table read, disks are converted sequentially
+	l2       []uint64
+	l2Offset uint64
+}
+
+// Parses the header and L1 table of a qcow2 image
+func openQCOW2(file io.ReaderAt) (*qcow2Image, error) {
+	header := new(qcow2Header)
+	err := binary.Read(io.NewSectionReader(file, 0, 80), binary.BigEndian, header)
+	if err != nil {
+		return nil, fmt.Errorf("[ERROR] Unable to read qcow2 header: %s", err)
+	}
+
+	if header.Magic != qcow2Magic {
+		return nil, fmt.Errorf("[ERROR] Not a qcow2 image")
+	}
+
+	switch {
+	case header.Version != 2 && header.Version != 3:
+		return nil, fmt.Errorf("[ERROR] Unsupported qcow2 version: %d", header.Version)
+	case header.ClusterBits < 9 || header.ClusterBits > 21:
+		return nil, fmt.Errorf("[ERROR] Invalid qcow2 cluster size: %d bits", header.ClusterBits)
+	case header.BackingFileOffset != 0:
+		return nil, fmt.Errorf("[ERROR] qcow2 images with a backing file are not supported")
+	case header.CryptMethod != 0:
+		return nil, fmt.Errorf("[ERROR] Encrypted qcow2 images are not supported")
+	}
+
+	if header.Version == 2 {
+		header.IncompatibleFeatures = 0
+	}
+	if header.IncompatibleFeatures&^qcow2FeatureDirty != 0 {
+		return nil, fmt.Errorf("[ERROR] Unsupported qcow2 features: %#x", header.IncompatibleFeatures)
+	}
+
+	img := &qcow2Image{
+		file:        file,
+		header:      header,
+		clusterSize: 1 << header.ClusterBits,
+		l1:          make([]uint64, header.L1Size),
+	}
+
+	err = binary.Read(io.NewSectionReader(file, int64(header.L1TableOffset), int64(header.L1Size)*8),
+		binary.BigEndian, img.l1)
+	if err != nil {
+		return nil, fmt.Errorf("[ERROR] Unable to read qcow2 L1 table: %s", err)
+	}
+
+	return img, nil
+}
+
+// Virtual size of the image in bytes
+func (img *qcow2Image) Size() uint64 {
+	return img.header.Size
+}
+
+// Looks up the L2 entry of the cluster at offset. Zero means the cluster is
+// not allocated.
+func (img *qcow2Image) l2Entry(offset uint64) (uint64, error) {
+	entries := img.clusterSize / 8
+	cluster := offset / img.clusterSize
+
+	l1Index := cluster / entries
+	if l1Index >= uint64(len(img.l1)) {
+		return 0, nil
+	}
+
+	l2Offset := img.l1[l1Index] & qcow2OffsetMask
+	if l2Offset == 0 {
+		return 0, nil
+	}
+
+	if l2Offset != img.l2Offset {
+		l2 := make([]uint64, entries)
+		err := binary.Read(io.NewSectionReader(img.file, int64(l2Offset), int64(img.clusterSize)),
+			binary.BigEndian, l2)
+		if err != nil {
+			return 0, fmt.Errorf("[ERROR] Unable to read qcow2 L2 table: %s", err)
+		}
+		img.l2, img.l2Offset = l2, l2Offset
+	}
+
+	return img.l2[cluster%entries], nil
+}
+
+// Reads the cluster described by an L2 entry into buf
+func (img *qcow2Image) readCluster(entry uint64, buf []byte) error {
+	if entry&qcow2Compressed == 0 {
+		offset := entry & qcow2OffsetMask
+		if offset == 0 || (img.header.Version == 3 && entry&qcow2Zero != 0) {
+			for i := range buf {
+				buf[i] = 0
+			}
+			return nil
+		}
+
+		_, err := img.file.ReadAt(buf, int64(offset))
+		return err
+	}
+
+	// Compressed clusters are raw deflate streams spanning a number of 512
+	// byte sectors, the last one may go past the end of the file.
+	bits := 62 - (img.header.ClusterBits - 8)
+	offset := entry & (1<<bits - 1)
+	sectors := (entry>>bits)&(1<<(img.header.ClusterBits-8)-1) + 1
+	length := sectors*512 - offset%512
+
+	compressed := make([]byte, length)
+	n, err := img.file.ReadAt(compressed, int64(offset))
+	if err != nil && err != io.EOF {
+		return err
+	}
+
+	_, err = io.ReadFull(flate.NewReader(bytes.NewReader(compressed[:n])), buf)
+	if err != nil {
+		return fmt.Errorf("[ERROR] Unable to decompress qcow2 cluster at %d: %s", offset, err)
+	}
+	return nil
+}
+
+// Reads guest data at offset, unallocated clusters read as zeros
+func (img *qcow2Image) ReadAt(p []byte, off int64) (int, error) {
+	if uint64(off) >= img.header.Size {
+		return 0, io.EOF
+	}
+
+	cluster := make([]byte, img.clusterSize)
+	n := 0
+	for n < len(p) && uint64(off) < img.header.Size {
+		entry, err := img.l2Entry(uint64(off))
+		if err != nil {
+			return n, err
+		}
+
+		if err = img.readCluster(entry, cluster); err != nil {
+			return n, err
+		}
+
+		start := uint64(off) % img.clusterSize
+		end := img.clusterSize
+		if remaining := img.header.Size - uint64(off) + start; remaining < end {
+			end = remaining
+		}
+
+		copied := copy(p[n:], cluster[start:end])
+		n += copied
+		off += int64(copied)
+	}
+
+	if n < len(p) {
+		return n, io.EOF
+	}
+	return n, nil
+}
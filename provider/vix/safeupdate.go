@@ -0,0 +1,141 @@
+package vix
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"strings"
+	"time"
+
+	govix "github.com/hooklift/govix"
+)
+
+// Prefix of snapshots taken before updating virtual machines
+const safeUpdatePrefix = "terraform-safe-update-"
+
+// Takes a snapshot of the powered off virtual machine, so Update can roll
+// back if it fails midway. Names sort in the order snapshots were taken.
+func takeSafeUpdateSnapshot(vm *govix.VM) (*govix.Snapshot, string, error) {
+	name := safeUpdatePrefix + time.Now().UTC().Format("20060102T150405.000000000Z")
+
+	log.Printf("[INFO] Taking snapshot %q before updating...", name)
+	snapshot, err := vm.CreateSnapshot(name, "Taken by Terraform before updating the virtual machine", 0)
+	if err != nil {
+		return nil, "", fmt.Errorf("[ERROR] Unable to take safe update snapshot: %s", err)
+	}
+
+	return snapshot, name, nil
+}
+
+// Update in progress, guarded by a snapshot taken beforehand
+type safeUpdate struct {
+	// Name of the snapshot
+	name string
+	// Reverts to the snapshot, restoring the prior power state
+	rollback func() error
+	// Removes safe update snapshots beyond v.SafeUpdateRetain
+	prune func() error
+}
+
+// Takes the snapshot guarding the update of the powered off virtual machine
+func (v *VM) beginSafeUpdate(vm *govix.VM, wasRunning bool) (*safeUpdate, error) {
+	snapshot, name, err := takeSafeUpdateSnapshot(vm)
+	if err != nil {
+		return nil, err
+	}
+
+	return &safeUpdate{
+		name:     name,
+		rollback: func() error { return v.rollback(vm, snapshot, wasRunning) },
+		prune:    func() error { return v.pruneSafeUpdateSnapshots(vm) },
+	}, nil
+}
+
+// Rolls the virtual machine back to the snapshot taken before updating it if
+// the update failed. Expired safe update snapshots are removed either way.
+// It returns the error of the update, if any.
+func (s *safeUpdate) finish(updateErr error) error {
+	if updateErr != nil {
+		log.Printf("[WARN] Update failed, rolling back to snapshot %q: %s", s.name, updateErr)
+
+		if err := s.rollback(); err != nil {
+			return fmt.Errorf("%s. Rolling back to snapshot %q failed as well, the "+
+				"snapshot was kept: %s", updateErr, s.name, err)
+		}
+	}
+
+	if err := s.prune(); err != nil {
+		log.Printf("[WARN] Unable to remove old safe update snapshots: %s", err)
+	}
+
+	return updateErr
+}
+
+// Reverts to the snapshot, which was taken powered off, and powers the
+// virtual machine back on if it was running.
+func (v *VM) rollback(vm *govix.VM, snapshot *govix.Snapshot, wasRunning bool) error {
+	if err := vm.RevertToSnapshot(snapshot, govix.VMPOWEROP_SUPPRESS_SNAPSHOT_POWERON); err != nil {
+		return err
+	}
+
+	if !wasRunning {
+		return nil
+	}
+
+	var options govix.VMPowerOption
+	if v.LaunchGUI {
+		options |= govix.VMPOWEROP_LAUNCH_GUI
+	}
+	options |= govix.VMPOWEROP_NORMAL
+
+	log.Println("[INFO] Powering virtual machine back on...")
+	return vm.PowerOn(options)
+}
+
+// Removes all safe update snapshots but the v.SafeUpdateRetain most recent
+// ones.
+func (v *VM) pruneSafeUpdateSnapshots(vm *govix.VM) error {
+	snapshots, err := listSnapshots(vm)
+	if err != nil {
+		return err
+	}
+
+	// Names are unique as they carry the time snapshots were taken. Paths are
+	// not used since they change as parents get removed.
+	var names []string
+	for _, snapshot := range snapshots {
+		if strings.HasPrefix(snapshot.Name, safeUpdatePrefix) {
+			names = append(names, snapshot.Name)
+		}
+	}
+
+	for _, name := range expiredSafeUpdateSnapshots(names, v.SafeUpdateRetain) {
+		snapshot, err := vm.SnapshotByName(name)
+		if err != nil {
+			return err
+		}
+
+		log.Printf("[DEBUG] Removing safe update snapshot %q", name)
+		if err = vm.RemoveSnapshot(snapshot, govix.SNAPSHOT_REMOVE_NONE); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Picks the snapshots to remove in order to only keep the most recent
+// retain ones.
+func expiredSafeUpdateSnapshots(names []string, retain int) []string {
+	sorted := append([]string{}, names...)
+	sort.Strings(sorted)
+
+	if retain < 0 {
+		retain = 0
+	}
+	if len(sorted) <= retain {
+		return nil
+	}
+
+	return sorted[:len(sorted)-retain]
+}
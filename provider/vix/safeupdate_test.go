@@ -0,0 +1,71 @@
+package vix
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestExpiredSafeUpdateSnapshots(t *testing.T) {
+	names := []string{
+		safeUpdatePrefix + "20160102T150405.000000000Z",
+		safeUpdatePrefix + "20150102T150405.000000000Z",
+		safeUpdatePrefix + "20170102T150405.000000000Z",
+	}
+
+	equals(t, []string{names[1], names[0], names[2]}, expiredSafeUpdateSnapshots(names, 0))
+	equals(t, []string{names[1]}, expiredSafeUpdateSnapshots(names, 2))
+	equals(t, []string(nil), expiredSafeUpdateSnapshots(names, 3))
+	equals(t, []string(nil), expiredSafeUpdateSnapshots(nil, 1))
+}
+
+// Fake safe update recording what was done
+type fakeSafeUpdate struct {
+	rolledBack  bool
+	pruned      bool
+	rollbackErr error
+}
+
+func (f *fakeSafeUpdate) safeUpdate() *safeUpdate {
+	return &safeUpdate{
+		name: safeUpdatePrefix + "20170102T150405.000000000Z",
+		rollback: func() error {
+			f.rolledBack = true
+			return f.rollbackErr
+		},
+		prune: func() error {
+			f.pruned = true
+			return errors.New("pruning is best effort")
+		},
+	}
+}
+
+// Mirrors how Update defers finishing safe updates, with updateErr as the
+// error the update fails with.
+func updateWithSafeUpdate(safe *safeUpdate, updateErr error) (err error) {
+	defer func() {
+		err = safe.finish(err)
+	}()
+
+	return updateErr
+}
+
+func TestSafeUpdateRollback(t *testing.T) {
+	fake := &fakeSafeUpdate{}
+	err := updateWithSafeUpdate(fake.safeUpdate(), errors.New("attaching disks failed"))
+	equals(t, "attaching disks failed", err.Error())
+	assert(t, fake.rolledBack, "failed updates should roll back")
+	assert(t, fake.pruned, "snapshots should be pruned")
+
+	fake = &fakeSafeUpdate{}
+	ok(t, updateWithSafeUpdate(fake.safeUpdate(), nil))
+	assert(t, !fake.rolledBack, "successful updates should not roll back")
+	assert(t, fake.pruned, "snapshots should be pruned")
+
+	// The snapshot is kept when rolling back fails, it is not pruned either
+	fake = &fakeSafeUpdate{rollbackErr: errors.New("revert failed")}
+	err = updateWithSafeUpdate(fake.safeUpdate(), errors.New("attaching disks failed"))
+	assert(t, err != nil && strings.Contains(err.Error(), "revert failed") &&
+		strings.Contains(err.Error(), "attaching disks failed"), "unexpected error: %v", err)
+	assert(t, !fake.pruned, "snapshots should not be pruned")
+}
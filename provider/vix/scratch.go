@@ -0,0 +1,168 @@
+package vix
+
+import (
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+	"github.com/hooklift/govmx"
+)
+
+// Virtual hardware version of virtual machines created from scratch, the one
+// introduced by Workstation 11 and Fusion 7.
+const scratchHardwareVersion = 11
+
+// Directory, under vmsDir, for virtual machines created from scratch
+const scratchSourceID = "scratch"
+
+// Whether the virtual machine is created from scratch, rather than cloned
+// from an image or another virtual machine.
+func (v *VM) fromScratch() bool {
+	return v.Image.URL == "" && v.SourceVMX == ""
+}
+
+// SCSI controller for the boot disk of virtual machines created from scratch,
+// along with the adapter type of the disk. Windows ships drivers for LSI
+// Logic SAS out of the box, whereas LSI Logic parallel is the most widely
+// supported one otherwise.
+func scratchSCSIController(guestOS string) (virtualDev, adapterType string) {
+	guestOS = strings.ToLower(guestOS)
+	if strings.HasPrefix(guestOS, "win") || strings.HasPrefix(guestOS, "longhorn") {
+		return "lsisas1068", "lsilogic"
+	}
+	return "lsilogic", "lsilogic"
+}
+
+// Builds the VMX model of a virtual machine created from scratch, booting off
+// disk. Network adapters, CD/DVD drives and the floppy image are attached by
+// Update, like for cloned virtual machines.
+func (v *VM) scratchVMX(disk, virtualDev string) (*vmx.VirtualMachine, error) {
+	memory, err := humanize.ParseBytes(v.Memory)
+	if err != nil {
+		return nil, fmt.Errorf("[ERROR] Invalid memory size %q: %s", v.Memory, err)
+	}
+
+	return &vmx.VirtualMachine{
+		Encoding:    "UTF-8",
+		Config:      vmx.Config{Version: "8"},
+		Vhardware:   vmx.Vhardware{Version: scratchHardwareVersion, Compat: "hosted"},
+		DisplayName: v.Name,
+		Annotation:  v.Description,
+		GuestOS:     v.GuestOS,
+		Memsize:     uint(memory / 1024 / 1024),
+		NumvCPUs:    v.CPUs,
+		PowerType: vmx.PowerType{
+			PowerOff: "soft",
+			PowerOn:  "soft",
+			Reset:    "soft",
+			Suspend:  "soft",
+		},
+		Tools: vmx.Tools{SyncTime: true},
+		VMCI:  vmx.VMCI{Present: true},
+		PCIBridges: []vmx.PCIBridge{
+			{Present: true},
+		},
+		SCSIDevices: []vmx.SCSIDevice{
+			{Device: vmx.Device{Present: true}, VirtualDev: virtualDev},
+			{Device: vmx.Device{Present: true, Filename: disk}},
+		},
+		// VMware attaches the host floppy drive unless told otherwise
+		FloppyDevices: []vmx.FloppyDevice{
+			{Present: false},
+		},
+	}, nil
+}
+
+// Writes the VMX file of a new virtual machine, along with its empty boot
+// disk of DiskSize bytes.
+func (v *VM) createFromScratch(vmxFile string) error {
+	v.SetDefaults()
+
+	if v.GuestOS == "" {
+		return fmt.Errorf("[ERROR] guest_os is required to create virtual machines from scratch")
+	}
+
+	size, err := humanize.ParseBytes(v.DiskSize)
+	if err != nil {
+		return fmt.Errorf("[ERROR] Invalid disk size %q: %s", v.DiskSize, err)
+	}
+
+	vmDir := filepath.Dir(vmxFile)
+	if err = os.MkdirAll(vmDir, 0740); err != nil {
+		return err
+	}
+
+	virtualDev, adapterType := scratchSCSIController(v.GuestOS)
+
+	hwVersion := scratchHardwareVersion
+	if v.HardwareVersion > 0 {
+		hwVersion = v.HardwareVersion
+	}
+
+	disk := v.Name + ".vmdk"
+	if err = createSparseVMDK(filepath.Join(vmDir, disk), size, adapterType, hwVersion); err != nil {
+		return err
+	}
+
+	model, err := v.scratchVMX(disk, virtualDev)
+	if err != nil {
+		return err
+	}
+	model.Vhardware.Version = hwVersion
+
+	data, err := vmx.Marshal(model)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Writing virtual machine %s from scratch", vmxFile)
+	return ioutil.WriteFile(vmxFile, data, 0644)
+}
+
+// Writes boot settings right before powering the virtual machine on, as
+// GoVMX drops the firmware when rewriting the VMX file. The firmware and its
+// secure boot setting are carried over from current, the VMX file as it was
+// before updating it, unless the firmware is set.
+func (v *VM) writeBootSettings(vmxFile string, current map[string]string) error {
+	return updateVMX(vmxFile, func(vmx map[string]string) error {
+		if v.GuestOS != "" {
+			vmx["guestos"] = v.GuestOS
+		}
+
+		firmware, secureBoot := v.Firmware, v.SecureBoot
+		if firmware == "" {
+			firmware = current["firmware"]
+			secureBoot = strings.EqualFold(current["uefi.secureboot.enabled"], "true")
+		}
+
+		if firmware != "" {
+			vmx["firmware"] = firmware
+		}
+
+		if firmware == "efi" && secureBoot {
+			vmx["uefi.secureboot.enabled"] = "TRUE"
+		} else {
+			delete(vmx, "uefi.secureboot.enabled")
+		}
+
+		if v.FloppyImage == "" {
+			vmx["floppy0.present"] = "FALSE"
+			delete(vmx, "floppy0.filetype")
+			delete(vmx, "floppy0.filename")
+			delete(vmx, "floppy0.startconnected")
+			return nil
+		}
+
+		log.Printf("[DEBUG] Attaching floppy image %s", v.FloppyImage)
+		vmx["floppy0.present"] = "TRUE"
+		vmx["floppy0.filetype"] = "file"
+		vmx["floppy0.filename"] = v.FloppyImage
+		vmx["floppy0.startconnected"] = "TRUE"
+
+		return nil
+	})
+}
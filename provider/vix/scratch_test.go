@@ -0,0 +1,82 @@
+package vix
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/hooklift/govmx"
+)
+
+func TestCreateFromScratch(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "terraform-vix")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+
+	v := &VM{
+		Name:     "win01",
+		GuestOS:  "windows9-64",
+		DiskSize: "60gib",
+		Memory:   "4gib",
+		CPUs:     2,
+	}
+	assert(t, v.fromScratch(), "VMs without image nor source_vmx are created from scratch")
+	equals(t, scratchSourceID, v.sourceID())
+
+	vmxFile := filepath.Join(dir, "win01", "win01.vmx")
+	ok(t, v.createFromScratch(vmxFile))
+
+	_, err = os.Stat(filepath.Join(dir, "win01", "win01.vmdk"))
+	ok(t, err)
+
+	data, err := ioutil.ReadFile(vmxFile)
+	ok(t, err)
+
+	model := new(vmx.VirtualMachine)
+	ok(t, vmx.Unmarshal(data, model))
+	equals(t, "windows9-64", model.GuestOS)
+	equals(t, uint(4096), model.Memsize)
+	equals(t, scratchHardwareVersion, model.Vhardware.Version)
+
+	raw, err := readVMX(vmxFile)
+	ok(t, err)
+	equals(t, "lsisas1068", raw["scsi0.virtualdev"])
+	equals(t, "win01.vmdk", raw["scsi0:0.filename"])
+	equals(t, "false", raw["floppy0.present"])
+
+	v.FloppyImage = "/isos/autounattend.flp"
+	v.Firmware = "efi"
+	v.SecureBoot = true
+	ok(t, v.writeBootSettings(vmxFile, nil))
+
+	raw, err = readVMX(vmxFile)
+	ok(t, err)
+	equals(t, "TRUE", raw["floppy0.present"])
+	equals(t, "/isos/autounattend.flp", raw["floppy0.filename"])
+	equals(t, "efi", raw["firmware"])
+	equals(t, "TRUE", raw["uefi.secureboot.enabled"])
+
+	// The firmware and secure boot are carried over unless the firmware is set
+	v.FloppyImage = ""
+	v.Firmware = ""
+	v.SecureBoot = false
+	ok(t, v.writeBootSettings(vmxFile, map[string]string{"firmware": "efi", "uefi.secureboot.enabled": "TRUE"}))
+
+	raw, err = readVMX(vmxFile)
+	ok(t, err)
+	equals(t, "FALSE", raw["floppy0.present"])
+	equals(t, "", raw["floppy0.filename"])
+	equals(t, "efi", raw["firmware"])
+	equals(t, "TRUE", raw["uefi.secureboot.enabled"])
+
+	v.Firmware = "bios"
+	v.GuestOS = "windows2019srv-64"
+	ok(t, v.writeBootSettings(vmxFile, raw))
+
+	raw, err = readVMX(vmxFile)
+	ok(t, err)
+	equals(t, "bios", raw["firmware"])
+	equals(t, "", raw["uefi.secureboot.enabled"])
+	equals(t, "windows2019srv-64", raw["guestos"])
+}
@@ -1 +1,285 @@
 package vix
+
+import (
+	"fmt"
+	"log"
+	"strings"
+
+	govix "github.com/hooklift/govix"
+)
+
+// Folder shared from the host to the guest through VMware Tools
+type SharedFolder struct {
+	// Name of the share, it is also the directory name inside the guest
+	Name string
+	// Whether the share is mounted in the guest or not
+	Enable bool
+	// Host directory to share
+	HostPath string
+	// Whether the guest is denied write access
+	ReadOnly bool
+	// Path where the share is reachable in the guest. If set, it is linked to
+	// where VMware Tools mounts the share, otherwise it is reported from there.
+	GuestPath string
+}
+
+// Maps share options to VIX flags
+func (s *SharedFolder) flags() govix.SharedFolderOption {
+	var flags govix.SharedFolderOption
+	if !s.ReadOnly {
+		flags |= govix.SHAREDFOLDER_WRITE_ACCESS
+	}
+	return flags
+}
+
+// Lists shares currently mounted in the guest, indexed by name. VMware Tools
+// has to be running.
+func sharedFolders(vm *govix.VM) (map[string]*SharedFolder, error) {
+	total, err := vm.TotalSharedFolders()
+	if err != nil {
+		return nil, err
+	}
+
+	shares := make(map[string]*SharedFolder, total)
+	for i := 0; i < total; i++ {
+		name, hostpath, flags, err := vm.SharedFolderState(i)
+		if err != nil {
+			return nil, err
+		}
+
+		shares[name] = &SharedFolder{
+			Name:     name,
+			Enable:   true,
+			HostPath: hostpath,
+			ReadOnly: govix.SharedFolderOption(flags)&govix.SHAREDFOLDER_WRITE_ACCESS == 0,
+		}
+	}
+
+	return shares, nil
+}
+
+// Whether shares in the guest are managed, either because shared folders are
+// enabled or because shares are or were configured.
+func (v *VM) managesShares() bool {
+	return v.SharedFolders || len(v.Shares) > 0 || len(v.RemovedShares) > 0
+}
+
+// Enables shared folders and syncs shares. VMware Tools has to be running.
+func (v *VM) setUpSharedFolders(vm *govix.VM) error {
+	if v.SharedFolders {
+		log.Println("[DEBUG] Enabling shared folders...")
+
+		err := vm.EnableSharedFolders(v.SharedFolders)
+		if err != nil {
+			return err
+		}
+	}
+
+	if v.managesShares() {
+		log.Println("[INFO] Syncing shared folders...")
+		if err := v.syncSharedFolders(vm); err != nil {
+			return err
+		}
+
+		return v.linkSharedFolders(vm)
+	}
+
+	return nil
+}
+
+// Adds or updates shares in the guest so they match v.Shares. Shares that are
+// not enabled, and the ones in v.RemovedShares, get removed from the guest.
+// Shares Terraform does not know about are left alone. VMware Tools has to be
+// running.
+func (v *VM) syncSharedFolders(vm *govix.VM) error {
+	current, err := sharedFolders(vm)
+	if err != nil {
+		return err
+	}
+
+	unwanted := v.unwantedShares()
+	for _, share := range v.Shares {
+		if !share.Enable {
+			continue
+		}
+
+		if _, ok := current[share.Name]; ok {
+			log.Printf("[DEBUG] Updating shared folder %s -> %s", share.Name, share.HostPath)
+			err = vm.SetSharedFolderState(share.Name, share.HostPath, share.flags())
+		} else {
+			log.Printf("[DEBUG] Adding shared folder %s -> %s", share.Name, share.HostPath)
+			err = vm.AddSharedFolder(share.Name, share.HostPath, share.flags())
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for name := range current {
+		if !unwanted[name] {
+			continue
+		}
+
+		log.Printf("[DEBUG] Removing shared folder %s", name)
+		if err = vm.RemoveSharedFolder(name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Names of the shares to remove from the guest, the ones removed from the
+// configuration or disabled.
+func (v *VM) unwantedShares() map[string]bool {
+	unwanted := make(map[string]bool)
+	for _, name := range v.RemovedShares {
+		unwanted[name] = true
+	}
+
+	for _, share := range v.Shares {
+		if share.Enable {
+			delete(unwanted, share.Name)
+		} else {
+			unwanted[share.Name] = true
+		}
+	}
+
+	return unwanted
+}
+
+// Refreshes v.Shares with the shares found in the guest. Configured shares
+// missing in the guest are reported as disabled, shares Terraform does not
+// know about are left out. VMware Tools has to be running.
+func (v *VM) readSharedFolders(vm *govix.VM) error {
+	current, err := sharedFolders(vm)
+	if err != nil {
+		return err
+	}
+
+	parentDir := v.sharedFoldersParentDir(vm)
+
+	shares := make([]*SharedFolder, 0, len(current))
+	for _, share := range v.Shares {
+		found, ok := current[share.Name]
+		if !ok {
+			shares = append(shares, &SharedFolder{
+				Name:      share.Name,
+				HostPath:  share.HostPath,
+				ReadOnly:  share.ReadOnly,
+				GuestPath: share.GuestPath,
+			})
+			continue
+		}
+
+		found.GuestPath = share.GuestPath
+		shares = append(shares, found)
+	}
+
+	// Paths set are linked to the mount point, they are kept as they are
+	for _, share := range shares {
+		if share.Enable && share.GuestPath == "" && parentDir != "" {
+			share.GuestPath = joinGuestPath(parentDir, share.Name)
+		}
+	}
+
+	v.Shares = shares
+
+	return nil
+}
+
+// Links the guest path of enabled shares to where VMware Tools mounts them,
+// replacing links made before. It needs guest credentials.
+func (v *VM) linkSharedFolders(vm *govix.VM) error {
+	var links []*SharedFolder
+	for _, share := range v.Shares {
+		if share.Enable && share.GuestPath != "" {
+			links = append(links, share)
+		}
+	}
+
+	if len(links) == 0 {
+		return nil
+	}
+
+	parentDir := v.sharedFoldersParentDir(vm)
+	if parentDir == "" {
+		log.Printf("[WARN] Unable to link shared folders to their guest_path, it is unknown where they are mounted.")
+		return nil
+	}
+
+	guest, err := vm.LoginInGuest(v.GuestUsername, v.GuestPassword, govix.LOGIN_IN_GUEST_NONE)
+	if err != nil {
+		return fmt.Errorf("[ERROR] Unable to log into the guest to link shared folders: %s", err)
+	}
+	defer guest.Logout()
+
+	for _, share := range links {
+		mount := joinGuestPath(parentDir, share.Name)
+		if share.GuestPath == mount {
+			continue
+		}
+
+		log.Printf("[DEBUG] Linking %s to shared folder %s", share.GuestPath, mount)
+		interpreter, script := linkGuestPathScript(mount, share.GuestPath)
+		_, _, exitCode, err := guest.RunScript(interpreter, script, govix.RUNPROGRAM_WAIT)
+		if err != nil {
+			return err
+		}
+		if exitCode != 0 {
+			return fmt.Errorf("[ERROR] Unable to link %s to shared folder %s, exit code %d",
+				share.GuestPath, mount, exitCode)
+		}
+	}
+
+	return nil
+}
+
+// Builds a script linking path to target in the guest, replacing the link if
+// it exists already. Directories that are not links are left alone, on
+// Windows rmdir only removes them if they are empty.
+func linkGuestPathScript(target, path string) (string, string) {
+	if isWindowsPath(path) {
+		return "", fmt.Sprintf("@if exist \"%s\" rmdir \"%s\"\r\n@mklink /D \"%s\" \"%s\"",
+			path, path, path, target)
+	}
+
+	path, target = shellQuote(path), shellQuote(target)
+	return "/bin/sh", fmt.Sprintf(`[ -d %s ] && [ ! -L %s ] && exit 1; mkdir -p "$(dirname %s)" && ln -sfn %s %s`,
+		path, path, path, target, path)
+}
+
+// Asks VMware Tools where shares are mounted in the guest. VIX only exposes it
+// in a guest session, so it needs guest credentials. An empty string is
+// returned if it cannot be found out.
+func (v *VM) sharedFoldersParentDir(vm *govix.VM) string {
+	if v.GuestUsername == "" {
+		log.Printf("[DEBUG] No guest credentials, unable to find out where shared folders are mounted.")
+		return ""
+	}
+
+	guest, err := vm.LoginInGuest(v.GuestUsername, v.GuestPassword, govix.LOGIN_IN_GUEST_NONE)
+	if err != nil {
+		log.Printf("[WARN] Unable to log into the guest: %s", err)
+		return ""
+	}
+	defer guest.Logout()
+
+	dir, err := guest.SharedFoldersParentDir()
+	if err != nil {
+		log.Printf("[WARN] Unable to get shared folders parent directory: %s", err)
+		return ""
+	}
+
+	return dir
+}
+
+// Joins guest paths using the separator the guest uses.
+func joinGuestPath(dir, name string) string {
+	separator := "/"
+	if strings.Contains(dir, `\`) {
+		separator = `\`
+	}
+	return strings.TrimRight(dir, separator) + separator + name
+}
@@ -0,0 +1,34 @@
+package vix
+
+import "testing"
+
+func TestLinkGuestPathScript(t *testing.T) {
+	interpreter, script := linkGuestPathScript("/mnt/hgfs/Dev1", "/home/core/dev")
+	equals(t, "/bin/sh", interpreter)
+	equals(t, `[ -d '/home/core/dev' ] && [ ! -L '/home/core/dev' ] && exit 1; `+
+		`mkdir -p "$(dirname '/home/core/dev')" && ln -sfn '/mnt/hgfs/Dev1' '/home/core/dev'`, script)
+
+	interpreter, script = linkGuestPathScript(`\\vmware-host\Shared Folders\Dev1`, `C:\dev`)
+	equals(t, "", interpreter)
+	equals(t, "@if exist \"C:\\dev\" rmdir \"C:\\dev\"\r\n"+
+		"@mklink /D \"C:\\dev\" \"\\\\vmware-host\\Shared Folders\\Dev1\"", script)
+}
+
+func TestJoinGuestPath(t *testing.T) {
+	equals(t, "/mnt/hgfs/Dev1", joinGuestPath("/mnt/hgfs/", "Dev1"))
+	equals(t, `\\vmware-host\Shared Folders\Dev1`, joinGuestPath(`\\vmware-host\Shared Folders`, "Dev1"))
+}
+
+func TestUnwantedShares(t *testing.T) {
+	v := &VM{
+		Shares: []*SharedFolder{
+			{Name: "Dev1", Enable: true, HostPath: "/src"},
+			{Name: "Dev2", HostPath: "/tmp"},
+		},
+		// Dev1 was renamed to Dev3 and back
+		RemovedShares: []string{"Dev1", "Old"},
+	}
+
+	// Shares Terraform does not know about are not listed
+	equals(t, map[string]bool{"Dev2": true, "Old": true}, v.unwantedShares())
+}
@@ -0,0 +1,315 @@
+package vix
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	govix "github.com/hooklift/govix"
+)
+
+// VIX error code returned when a snapshot does not exist
+const snapshotNotFound = 13003
+
+// Virtual machine snapshot
+type Snapshot struct {
+	// Name of the snapshot, VMware does not enforce it to be unique
+	Name string
+	// Description of the snapshot
+	Description string
+	// Whether to capture the memory of a running virtual machine as well
+	IncludeMemory bool
+	// Name of the parent snapshot, empty for root snapshots
+	Parent string
+	// Names from the root snapshot down to this one, separated by "/". VMware
+	// accepts them wherever snapshot names are expected.
+	Path string
+	// Whether the virtual machine is currently running off this snapshot
+	Current bool
+}
+
+// Opens the virtual machine, the client has to be disconnected once done with
+// it.
+func (v *VM) open(vmxFile string) (*govix.Host, *govix.VM, error) {
+	client, err := v.client()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	vm, err := client.OpenVM(vmxFile, v.Image.Password)
+	if err != nil {
+		client.Disconnect()
+		return nil, nil, err
+	}
+
+	return client, vm, nil
+}
+
+// Whether err means the snapshot does not exist
+func isSnapshotNotFound(err error) bool {
+	verr, ok := err.(*govix.Error)
+	return ok && verr.Code == snapshotNotFound
+}
+
+// Takes a snapshot of the virtual machine.
+func (v *VM) CreateSnapshot(vmxFile string, snapshot *Snapshot) error {
+	client, vm, err := v.open(vmxFile)
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect()
+
+	var options govix.CreateSnapshotOption
+	if snapshot.IncludeMemory {
+		options |= govix.SNAPSHOT_INCLUDE_MEMORY
+	}
+
+	log.Printf("[INFO] Taking snapshot %q of %s...", snapshot.Name, vmxFile)
+	_, err = vm.CreateSnapshot(snapshot.Name, snapshot.Description, options)
+
+	return err
+}
+
+// Looks a snapshot up by name. It returns nil if either the snapshot or the
+// virtual machine are gone. Descriptions are not fetched, VIX does not
+// reliably return them.
+func (v *VM) ReadSnapshot(vmxFile, name string) (*Snapshot, error) {
+	if _, err := os.Stat(vmxFile); os.IsNotExist(err) {
+		return nil, nil
+	}
+
+	client, vm, err := v.open(vmxFile)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Disconnect()
+
+	_, err = vm.SnapshotByName(name)
+	if isSnapshotNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &Snapshot{Name: name}, nil
+}
+
+// Reverts the virtual machine to a snapshot. The virtual machine ends up in
+// the power state it had when the snapshot was taken.
+func (v *VM) RevertToSnapshot(vmxFile, name string) error {
+	client, vm, err := v.open(vmxFile)
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect()
+
+	snapshot, err := vm.SnapshotByName(name)
+	if err != nil {
+		return fmt.Errorf("[ERROR] Unable to find snapshot %q: %s", name, err)
+	}
+
+	var options govix.VMPowerOption
+	if v.LaunchGUI {
+		options |= govix.VMPOWEROP_LAUNCH_GUI
+	}
+
+	log.Printf("[INFO] Reverting %s to snapshot %q...", vmxFile, name)
+	return vm.RevertToSnapshot(snapshot, options)
+}
+
+// Removes a snapshot, merging its changes into its children. Snapshots that
+// are already gone are ignored.
+func (v *VM) RemoveSnapshot(vmxFile, name string) error {
+	if _, err := os.Stat(vmxFile); os.IsNotExist(err) {
+		return nil
+	}
+
+	client, vm, err := v.open(vmxFile)
+	if err != nil {
+		return err
+	}
+	defer client.Disconnect()
+
+	snapshot, err := vm.SnapshotByName(name)
+	if isSnapshotNotFound(err) {
+		log.Printf("[DEBUG] Snapshot %q of %s is already gone", name, vmxFile)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Removing snapshot %q of %s...", name, vmxFile)
+	return vm.RemoveSnapshot(snapshot, govix.SNAPSHOT_REMOVE_NONE)
+}
+
+// Lists all the snapshots of the virtual machine, parents first.
+func (v *VM) Snapshots(vmxFile string) ([]*Snapshot, error) {
+	client, vm, err := v.open(vmxFile)
+	if err != nil {
+		return nil, err
+	}
+	defer client.Disconnect()
+
+	snapshots, err := listSnapshots(vm)
+	if err != nil || len(snapshots) == 0 {
+		return snapshots, err
+	}
+
+	current, err := vm.CurrentSnapshot()
+	if err != nil {
+		log.Printf("[WARN] Unable to find out the current snapshot of %s: %s", vmxFile, err)
+		return snapshots, nil
+	}
+
+	currentPath, err := snapshotPath(current)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, snapshot := range snapshots {
+		snapshot.Current = snapshot.Path == currentPath
+	}
+
+	return snapshots, nil
+}
+
+// Walks the snapshot tree of an open virtual machine, parents first.
+func listSnapshots(vm *govix.VM) ([]*Snapshot, error) {
+	roots, err := vm.TotalRootSnapshots()
+	if err != nil {
+		return nil, err
+	}
+
+	var snapshots []*Snapshot
+	for i := 0; i < roots; i++ {
+		root, err := vm.RootSnapshot(i)
+		if err != nil {
+			return nil, err
+		}
+
+		if snapshots, err = walkSnapshots(root, nil, snapshots); err != nil {
+			return nil, err
+		}
+	}
+
+	return snapshots, nil
+}
+
+// Appends snapshot and its descendants to snapshots, depth first.
+func walkSnapshots(snapshot *govix.Snapshot, parent *Snapshot, snapshots []*Snapshot) ([]*Snapshot, error) {
+	name, err := snapshot.Name()
+	if err != nil {
+		return nil, err
+	}
+
+	description, err := snapshot.Description()
+	if err != nil {
+		return nil, err
+	}
+
+	node := &Snapshot{Name: name, Description: description, Path: name}
+	if parent != nil {
+		node.Parent = parent.Name
+		node.Path = parent.Path + "/" + name
+	}
+	snapshots = append(snapshots, node)
+
+	children, err := snapshot.NumChildren()
+	if err != nil {
+		return nil, err
+	}
+
+	for i := 0; i < children; i++ {
+		child, err := snapshot.Child(i)
+		if err != nil {
+			return nil, err
+		}
+
+		if snapshots, err = walkSnapshots(child, node, snapshots); err != nil {
+			return nil, err
+		}
+	}
+
+	return snapshots, nil
+}
+
+// Builds the path of a snapshot walking up to its root snapshot.
+func snapshotPath(snapshot *govix.Snapshot) (string, error) {
+	name, err := snapshot.Name()
+	if err != nil {
+		return "", err
+	}
+
+	names := []string{name}
+	for {
+		// Root snapshots have no parent to get or name
+		parent, err := snapshot.Parent()
+		if err != nil {
+			break
+		}
+
+		name, err := parent.Name()
+		if err != nil {
+			break
+		}
+
+		names = append([]string{name}, names...)
+		snapshot = parent
+	}
+
+	return strings.Join(names, "/"), nil
+}
+
+// Clones a snapshot of a powered off virtual machine. GoVIX is only able to
+// clone the current state, so the virtual machine is reverted to the snapshot
+// in order to clone it, and then back to a snapshot of its prior state.
+func cloneSnapshot(vm *govix.VM, name string, cloneType govix.CloneType, dest string) (err error) {
+	running, err := vm.IsRunning()
+	if err != nil {
+		return err
+	}
+
+	if running {
+		return fmt.Errorf("[ERROR] Source virtual machine has to be powered off " +
+			"in order to clone one of its snapshots")
+	}
+
+	snapshot, err := vm.SnapshotByName(name)
+	if err != nil {
+		return fmt.Errorf("[ERROR] Unable to find snapshot %q: %s", name, err)
+	}
+
+	restoreName := "terraform-clone-restore-" + time.Now().UTC().Format("20060102T150405Z")
+
+	log.Printf("[DEBUG] Taking snapshot %q to restore source virtual machine later", restoreName)
+	restore, err := vm.CreateSnapshot(restoreName, "Taken by Terraform to restore "+
+		"the virtual machine after cloning one of its snapshots", 0)
+	if err != nil {
+		return err
+	}
+
+	defer func() {
+		log.Printf("[DEBUG] Restoring source virtual machine to snapshot %q", restoreName)
+		if rerr := vm.RevertToSnapshot(restore, govix.VMPOWEROP_SUPPRESS_SNAPSHOT_POWERON); rerr != nil {
+			err = fmt.Errorf("[ERROR] Unable to restore source virtual machine, "+
+				"its prior state was kept as snapshot %q: %s", restoreName, rerr)
+			return
+		}
+
+		if rerr := vm.RemoveSnapshot(restore, govix.SNAPSHOT_REMOVE_NONE); rerr != nil {
+			log.Printf("[WARN] Unable to remove snapshot %q: %s", restoreName, rerr)
+		}
+	}()
+
+	log.Printf("[DEBUG] Reverting source virtual machine to snapshot %q", name)
+	if err = vm.RevertToSnapshot(snapshot, govix.VMPOWEROP_SUPPRESS_SNAPSHOT_POWERON); err != nil {
+		return err
+	}
+
+	_, err = vm.Clone(cloneType, dest)
+	return err
+}
@@ -0,0 +1,277 @@
+package vix
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	govix "github.com/hooklift/govix"
+)
+
+// Templates are kept in the gold cache, next to the virtual machines
+// extracted from images, under template-<name>.
+const templateDirPrefix = "template-"
+
+// Snapshot of templates their linked clones are made of
+const TemplateSnapshot = "terraform-template"
+
+// Virtual machine promoted into a reusable template
+type Template struct {
+	// Name of the template, unique within the gold cache
+	Name string
+	// Whether to fill the free space of the guest file systems with zeros
+	// before cloning the virtual machine, so the template disks compact
+	// better. Requires guest credentials.
+	ZeroFreeSpace bool
+}
+
+// Returns the directory where Gold virtual machines live
+func goldDir() (string, error) {
+	vms, err := vmsDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(filepath.Dir(vms), "gold"), nil
+}
+
+// Returns the vmx file of the template with the given name
+func TemplateVMX(name string) (string, error) {
+	dir, err := goldDir()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(dir, templateDirPrefix+name, name+".vmx"), nil
+}
+
+// Shuts the virtual machine down, clones it into the gold cache stripped of
+// its identity and takes the snapshot linked clones are made of. The virtual
+// machine is powered back on if it was running. It returns the vmx file of
+// the template.
+func (v *VM) CreateTemplate(vmxFile string, template *Template) (tmplVMX string, err error) {
+	v.SetDefaults()
+
+	if tmplVMX, err = TemplateVMX(template.Name); err != nil {
+		return "", err
+	}
+
+	// Nothing is ever removed from a directory this call did not create
+	dir := filepath.Dir(tmplVMX)
+	if _, err = os.Stat(dir); err == nil {
+		return "", fmt.Errorf("[ERROR] Template %q already exists: %s", template.Name, dir)
+	}
+
+	client, vm, err := v.open(vmxFile)
+	if err != nil {
+		return "", err
+	}
+	defer client.Disconnect()
+
+	wasRunning, err := vm.IsRunning()
+	if err != nil {
+		return "", err
+	}
+
+	options := govix.VMPOWEROP_NORMAL
+	if v.LaunchGUI {
+		options |= govix.VMPOWEROP_LAUNCH_GUI
+	}
+
+	if wasRunning {
+		defer func() {
+			log.Println("[INFO] Powering virtual machine back on...")
+			if perr := vm.PowerOn(options); perr != nil && err == nil {
+				err = perr
+			}
+		}()
+	}
+
+	running := wasRunning
+	if template.ZeroFreeSpace {
+		if !running {
+			log.Println("[INFO] Powering virtual machine on to zero its free space...")
+			if err = vm.PowerOn(options); err != nil {
+				return "", err
+			}
+			running = true
+		}
+
+		vmx, err := readVMX(vmxFile)
+		if err != nil {
+			return "", err
+		}
+
+		if err = v.zeroFreeSpace(vm, vmx["guestos"]); err != nil {
+			v.powerOff(vm)
+			return "", err
+		}
+	}
+
+	if running {
+		if err = v.powerOff(vm); err != nil {
+			return "", err
+		}
+	}
+
+	// The directory is created by the clone, so it is removed if anything
+	// fails from here on.
+	log.Printf("[INFO] Cloning %s into template %s...", vmxFile, tmplVMX)
+	if _, err = vm.Clone(govix.CLONETYPE_FULL, tmplVMX); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+
+	if err = updateVMX(tmplVMX, func(vmx map[string]string) error {
+		stripIdentity(vmx)
+		return nil
+	}); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+
+	tmpl, err := client.OpenVM(tmplVMX, v.Image.Password)
+	if err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+
+	log.Printf("[INFO] Taking snapshot %q of template %s...", TemplateSnapshot, tmplVMX)
+	if _, err = tmpl.CreateSnapshot(TemplateSnapshot, "Taken by Terraform, linked clones of the template are made of it", 0); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+
+	return tmplVMX, nil
+}
+
+// Fills the free space of the guest file systems with zeros, then removes the
+// filler file. VMware Tools has to be running or about to.
+func (v *VM) zeroFreeSpace(vm *govix.VM, guestOS string) error {
+	log.Println("[INFO] Waiting for VMware Tools to initialize...")
+	if err := vm.WaitForToolsInGuest(v.ToolsInitTimeout); err != nil {
+		return err
+	}
+
+	guest, err := vm.LoginInGuest(v.GuestUsername, v.GuestPassword, govix.LOGIN_IN_GUEST_NONE)
+	if err != nil {
+		return fmt.Errorf("[ERROR] Unable to log into the guest to zero its free space: %s", err)
+	}
+	defer guest.Logout()
+
+	interpreter, script := zeroFreeSpaceScript(guestOS)
+
+	log.Println("[INFO] Zeroing free space of the guest...")
+	_, _, exitCode, err := guest.RunScript(interpreter, script, govix.RUNPROGRAM_WAIT)
+	if err != nil {
+		return fmt.Errorf("[ERROR] Unable to zero free space of the guest: %s", err)
+	}
+
+	if exitCode != 0 {
+		return fmt.Errorf("[ERROR] Zeroing free space of the guest exited with code %d", exitCode)
+	}
+
+	return nil
+}
+
+// Returns the interpreter and script that zero the free space of the guest.
+// Filling the disk up is expected to fail, so the exit code of the filler
+// command is ignored. Windows guests get a batch script, which needs no
+// interpreter.
+func zeroFreeSpaceScript(guestOS string) (string, string) {
+	if strings.HasPrefix(strings.ToLower(guestOS), "win") {
+		return "", "powershell -NoProfile -Command \"$f = Join-Path $env:SystemDrive 'zerofree'; " +
+			"$b = New-Object byte[] 1048576; $s = [IO.File]::OpenWrite($f); " +
+			"try { while ($true) { $s.Write($b, 0, $b.Length) } } catch {} finally { $s.Close() }; " +
+			"Remove-Item -Force $f\"\r\n"
+	}
+
+	return "/bin/sh", "dd if=/dev/zero of=/zerofree bs=1M 2>/dev/null\nrm -f /zerofree\nsync\n"
+}
+
+// Settings tying network adapters to this particular virtual machine
+var ethernetIdentityRegexp = regexp.MustCompile(`^ethernet\d+\.(generatedaddress|generatedaddressoffset|address)$`)
+
+// Removes the UUIDs and MAC addresses of the virtual machine, so VMware
+// generates new ones for each clone instead of asking whether the virtual
+// machine was moved or copied. Static adapters lose their address as well, so
+// they are switched to generated addresses, otherwise VMware would bring them
+// up without any MAC address.
+func stripIdentity(vmx map[string]string) {
+	for key := range vmx {
+		switch {
+		case key == "uuid.bios", key == "uuid.location", key == "vc.uuid",
+			ethernetIdentityRegexp.MatchString(key):
+			delete(vmx, key)
+		case strings.HasSuffix(key, ".addresstype") && strings.HasPrefix(key, "ethernet"):
+			vmx[key] = "generated"
+		}
+	}
+
+	vmx["uuid.action"] = "create"
+}
+
+// Removes the template, refusing to do so while managed virtual machines are
+// linked clones of it.
+func DestroyTemplate(tmplVMX string) error {
+	dir := filepath.Dir(tmplVMX)
+
+	files, err := managedVMXFiles()
+	if err != nil {
+		return err
+	}
+
+	for _, vmxFile := range files {
+		linked, err := isLinkedTo(vmxFile, dir)
+		if err != nil {
+			return err
+		}
+
+		if linked {
+			return fmt.Errorf("[ERROR] Unable to remove template %s, %s is a linked clone of it", tmplVMX, vmxFile)
+		}
+	}
+
+	log.Printf("[INFO] Removing template %s...", dir)
+	return os.RemoveAll(dir)
+}
+
+// Whether any disk of the virtual machine descends from a disk in dir
+func isLinkedTo(vmxFile, dir string) (bool, error) {
+	vmx, err := readVMX(vmxFile)
+	if err != nil {
+		return false, err
+	}
+
+	prefix := filepath.Clean(dir) + string(filepath.Separator)
+	for _, disk := range vmxDisks(vmx) {
+		path := vmdkPath(filepath.Dir(vmxFile), disk.Path)
+
+		// Follows the chain of delta disks up to the base disk
+		for path != "" {
+			if strings.HasPrefix(filepath.Clean(path), prefix) {
+				return true, nil
+			}
+
+			descriptor, _, err := readVMDKDescriptor(path)
+			if os.IsNotExist(err) {
+				break
+			}
+			if err != nil {
+				return false, err
+			}
+
+			parent := descriptorValue(descriptor, "parentCID")
+			hint := descriptorValue(descriptor, "parentFileNameHint")
+			if parent == "" || strings.EqualFold(parent, "ffffffff") || hint == "" {
+				break
+			}
+			path = vmdkPath(filepath.Dir(path), hint)
+		}
+	}
+
+	return false, nil
+}
@@ -0,0 +1,76 @@
+package vix
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestStripIdentity(t *testing.T) {
+	vmx := map[string]string{
+		"uuid.bios":                        "56 4d 2a 9e",
+		"uuid.location":                    "56 4d 2a 9e",
+		"vc.uuid":                          "52 1c 3d 7f",
+		"ethernet0.present":                "TRUE",
+		"ethernet0.addresstype":            "generated",
+		"ethernet0.generatedaddress":       "00:0c:29:1a:2b:3c",
+		"ethernet0.generatedaddressoffset": "0",
+		"ethernet1.addresstype":            "static",
+		"ethernet1.address":                "00:50:56:00:00:01",
+		"memsize":                          "1024",
+	}
+
+	stripIdentity(vmx)
+
+	// The static adapter is switched to a generated address
+	equals(t, map[string]string{
+		"ethernet0.present":     "TRUE",
+		"ethernet0.addresstype": "generated",
+		"ethernet1.addresstype": "generated",
+		"memsize":               "1024",
+		"uuid.action":           "create",
+	}, vmx)
+}
+
+func TestZeroFreeSpaceScript(t *testing.T) {
+	interpreter, _ := zeroFreeSpaceScript("ubuntu-64")
+	equals(t, "/bin/sh", interpreter)
+
+	interpreter, _ = zeroFreeSpaceScript("windows9-64")
+	equals(t, "", interpreter)
+}
+
+func TestIsLinkedTo(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "terraform-vix")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+
+	tmplDir := filepath.Join(dir, "gold", "template-base")
+	vmDir := filepath.Join(dir, "vms", "web01")
+	ok(t, os.MkdirAll(tmplDir, 0740))
+	ok(t, os.MkdirAll(vmDir, 0740))
+
+	base := filepath.Join(tmplDir, "base.vmdk")
+	ok(t, ioutil.WriteFile(base, []byte("# Disk DescriptorFile\nparentCID=ffffffff\n"), 0644))
+
+	// The clone has a snapshot of its own, so the template is two levels up
+	ok(t, ioutil.WriteFile(filepath.Join(vmDir, "web01.vmdk"), []byte(
+		"# Disk DescriptorFile\nparentCID=1a2b3c4d\nparentFileNameHint=\""+base+"\"\n"), 0644))
+	ok(t, ioutil.WriteFile(filepath.Join(vmDir, "web01-000001.vmdk"), []byte(
+		"# Disk DescriptorFile\nparentCID=5e6f7a8b\nparentFileNameHint=\"web01.vmdk\"\n"), 0644))
+
+	vmxFile := filepath.Join(vmDir, "web01.vmx")
+	ok(t, writeVMX(vmxFile, map[string]string{
+		"scsi0:0.present":  "TRUE",
+		"scsi0:0.filename": "web01-000001.vmdk",
+	}))
+
+	linked, err := isLinkedTo(vmxFile, tmplDir)
+	ok(t, err)
+	assert(t, linked, "web01 is a linked clone of the template")
+
+	linked, err = isLinkedTo(vmxFile, filepath.Join(dir, "gold", "template-other"))
+	ok(t, err)
+	assert(t, !linked, "web01 is not a linked clone of template-other")
+}
@@ -0,0 +1,65 @@
+package vix
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWriteTuning(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "terraform-vix")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+
+	vmxFile := filepath.Join(dir, "lab01.vmx")
+	ok(t, writeVMX(vmxFile, map[string]string{"numvcpus": "4"}))
+
+	nested, pshare, scan := true, false, 0
+	v := &VM{Tuning: Tuning{
+		CoresPerSocket:       2,
+		NestedVirtualization: &nested,
+		PageSharing:          &pshare,
+		ShareScanTotal:       &scan,
+		BalloonLimit:         "1gib",
+	}}
+
+	// Settings not set are carried over, as GoVMX drops them
+	current := map[string]string{"vpmc.enable": "TRUE", "cpuid.corespersocket": "4"}
+	ok(t, v.writeTuning(vmxFile, current))
+
+	vmx, err := readVMX(vmxFile)
+	ok(t, err)
+	equals(t, map[string]string{
+		"numvcpus":                "4",
+		"cpuid.corespersocket":    "2",
+		"vhv.enable":              "TRUE",
+		"vpmc.enable":             "TRUE",
+		"sched.mem.pshare.enable": "FALSE",
+		"mem.sharescantotal":      "0",
+		"sched.mem.maxmemctl":     "1024",
+	}, vmx)
+
+	tuning := readTuning(vmx)
+	equals(t, 2, tuning.CoresPerSocket)
+	equals(t, true, *tuning.NestedVirtualization)
+	equals(t, true, *tuning.PerformanceCounters)
+	equals(t, false, *tuning.PageSharing)
+	equals(t, 0, *tuning.ShareScanTotal)
+	equals(t, "1024mib", tuning.BalloonLimit)
+
+	// GoVMX keeps vhv.enable when it is TRUE, disabling it still wins
+	nested = false
+	v.Tuning = Tuning{NestedVirtualization: &nested}
+	ok(t, v.writeTuning(vmxFile, vmx))
+
+	vmx, err = readVMX(vmxFile)
+	ok(t, err)
+	equals(t, "FALSE", vmx["vhv.enable"])
+
+	// VMware defaults
+	tuning = readTuning(map[string]string{})
+	equals(t, 1, tuning.CoresPerSocket)
+	equals(t, true, *tuning.PageSharing)
+	assert(t, tuning.ShareScanTotal == nil, "share scan total is not set")
+}
@@ -1,6 +1,8 @@
 package vix
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"fmt"
 	"io/ioutil"
 	"log"
@@ -8,6 +10,7 @@ import (
 	"os/user"
 	"path/filepath"
 	"runtime/debug"
+	"strconv"
 	"strings"
 	"time"
 
@@ -29,13 +32,48 @@ type VM struct {
 	Description string
 	// Image to use during the creation of this virtual machine
 	Image Image
+	// Existing virtual machine to clone instead of the image
+	SourceVMX string
+	// Snapshot of SourceVMX to clone, its current state is cloned if empty
+	SourceSnapshot string
+	// Whether to create full or linked clones
+	CloneType govix.CloneType
+	// Guest operating system identifier, such as ubuntu-64 or windows9-64.
+	// Required to create virtual machines from scratch or out of plain disk
+	// images, it is left untouched if empty otherwise.
+	GuestOS string
+	// Size of the boot disk, such as 40gib. Virtual machines created from
+	// scratch get an empty disk this size, whereas the boot disk of cloned ones
+	// is grown to it.
+	DiskSize string
+	// ISO image to boot from, attached as the first CD/DVD drive
+	BootISO string
+	// Floppy image to attach, such as one carrying autounattend.xml
+	FloppyImage string
+	// Either bios or efi, it is left untouched if empty
+	Firmware string
+	// Whether to enable UEFI secure boot, only applied along with Firmware
+	SecureBoot bool
+	// Virtual hardware version to pin the virtual machine to, it is left
+	// untouched if zero. Virtual hardware can not be downgraded.
+	HardwareVersion int
+	// Additional virtual disks
+	Disks []*Disk
+	// Disks to detach, they were removed from the configuration
+	DetachedDisks []*Disk
 	// Number of virtual cpus
 	CPUs uint
+	// CPU topology and resource controls
+	Tuning Tuning
+	// VNC server of the console, nil to disable it
+	VNC *VNC
 	// Memory size in megabytes.
 	Memory string
-	// Switches to where this machine is going to be attach to
+	// Switches to where this machine is going to be attach to. There is one
+	// entry per network adapter, empty unless the adapter is of custom type.
 	VSwitches []string
-	// Whether to upgrade the VM virtual hardware
+	// Whether to upgrade the VM virtual hardware to the latest version
+	// supported by the host, ignored if HardwareVersion is set
 	UpgradeVHardware bool
 	// The timeout to wait for VMware Tools to be initialized inside the VM
 	ToolsInitTimeout time.Duration
@@ -43,12 +81,41 @@ type VM struct {
 	LaunchGUI bool
 	// Whether to enable or disable shared folders for this VM
 	SharedFolders bool
+	// Folders to share with the guest
+	Shares []*SharedFolder
+	// Names of shares removed from the configuration, so they are removed
+	// from the guest as well
+	RemovedShares []string
+	// Guest credentials for operations that require a guest session
+	GuestUsername string
+	GuestPassword string
 	// Network adapters
 	VNetworkAdapters []*govix.NetworkAdapter
 	// CD/DVD drives
 	CDDVDDrives []*govix.CDDVDDrive
 	// VM IP address as reported by VIX
 	IPAddress string
+	// How to wait for the guest to get an IP address, nil to not wait at all
+	WaitForIP *WaitForIP
+	// Variables exposed to the guest as guestinfo.<name>. Nil values are
+	// carried over from the VMX file.
+	GuestInfo map[string]*string
+	// Names of guestinfo variables set before that are no longer wanted, they
+	// are removed from the VMX file.
+	StaleGuestInfo []string
+	// cloud-init NoCloud seed attached as an extra CD/DVD drive, nil to not
+	// attach any
+	CloudInit *CloudInit
+	// Files to upload to the guest once VMware Tools is running
+	GuestFiles []*GuestFile
+	// Scripts to run inside the guest once VMware Tools is running
+	GuestExecs []*GuestExec
+	// Whether to snapshot the VM before updating it, rolling back if it fails
+	SafeUpdate bool
+	// Number of safe update snapshots to keep around once updates succeed
+	SafeUpdateRetain int
+	// Whether Update is being called as part of Create
+	creating bool
 }
 
 // Creates VIX instance with VMware
@@ -108,18 +175,72 @@ func (v *VM) SetDefaults() {
 	}
 }
 
-// Downloads, extracts and opens Gold virtual machine, then it creates a clone
-// out of it.
-func (v *VM) Create() (string, error) {
-	log.Printf("[DEBUG] Creating VM resource...")
+// Parses a memory size, such as 1gib, into megabytes. VMware requires it to be
+// a multiple of 4 megabytes.
+func ParseMemory(size string) (uint, error) {
+	bytes, err := humanize.ParseBytes(size)
+	if err != nil {
+		return 0, fmt.Errorf("[ERROR] Invalid memory size %q: %s", size, err)
+	}
+
+	const unit = 4 * 1024 * 1024
+	if bytes == 0 || bytes%unit != 0 {
+		return 0, fmt.Errorf("[ERROR] Memory size %q has to be a non-zero multiple of 4mib", size)
+	}
+
+	return uint(bytes / 1024 / 1024), nil
+}
+
+// Returns the directory where virtual machines managed by this provider live.
+func vmsDir() (string, error) {
+	usr, err := user.Current()
+	if err != nil {
+		return "", err
+	}
+
+	return filepath.Join(usr.HomeDir, ".terraform", "vix", "vms"), nil
+}
+
+// Lists the vmx files of all the virtual machines managed by this provider.
+func managedVMXFiles() ([]string, error) {
+	dir, err := vmsDir()
+	if err != nil {
+		return nil, err
+	}
+
+	var files []string
+	err = filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+
+		if !info.IsDir() && filepath.Ext(path) == ".vmx" {
+			files = append(files, path)
+		}
+		return nil
+	})
 
+	return files, err
+}
+
+// Downloads and extracts the Gold virtual machine if it is not there already,
+// returning its vmx file.
+func (v *VM) goldVMX() (string, error) {
 	usr, err := user.Current()
 	if err != nil {
 		return "", err
 	}
 
+	gold, err := goldDir()
+	if err != nil {
+		return "", err
+	}
+
 	image := v.Image
-	goldPath := filepath.Join(usr.HomeDir, filepath.Join(".terraform/vix/gold", image.Checksum))
+	goldPath := filepath.Join(gold, image.Checksum)
 	_, err = os.Stat(goldPath)
 	finfo, _ := ioutil.ReadDir(goldPath)
 	goldPathEmpty := len(finfo) == 0
@@ -139,12 +260,18 @@ func (v *VM) Create() (string, error) {
 			return "", err
 		}
 
-		log.Printf("[DEBUG] Unpacking Gold virtual machine into %s\n", goldPath)
-		_, err = unpackit.Unpack(image.file, goldPath)
-		if err != nil {
-			debug.PrintStack()
-			log.Printf("[ERROR] Unpacking Gold image %s\n", image.file.Name())
-			return "", err
+		if image.isDisk() {
+			if err = v.importDiskImage(image.file, goldPath); err != nil {
+				return "", err
+			}
+		} else {
+			log.Printf("[DEBUG] Unpacking Gold virtual machine into %s\n", goldPath)
+			_, err = unpackit.Unpack(image.file, goldPath)
+			if err != nil {
+				debug.PrintStack()
+				log.Printf("[ERROR] Unpacking Gold image %s\n", image.file.Name())
+				return "", err
+			}
 		}
 	}
 
@@ -157,47 +284,103 @@ func (v *VM) Create() (string, error) {
 		return "", fmt.Errorf("[ERROR] vmx file was not found: %s", pattern)
 	}
 
-	vmxFile := files[0]
-	log.Printf("[DEBUG] Gold virtual machine vmx file found %v", vmxFile)
+	return files[0], nil
+}
+
+// Identifies the source of a clone, so clones of different sources end up in
+// different directories.
+func (v *VM) sourceID() string {
+	if v.fromScratch() {
+		return scratchSourceID
+	}
+
+	if v.SourceVMX == "" {
+		return v.Image.Checksum
+	}
+
+	sum := sha256.Sum256([]byte(v.SourceVMX + "@" + v.SourceSnapshot))
+	return "source-" + hex.EncodeToString(sum[:8])
+}
+
+// Clones either the Gold virtual machine or the source virtual machine into
+// newvmx.
+func (v *VM) clone(newvmx string) error {
+	vmxFile := v.SourceVMX
+	if vmxFile == "" {
+		var err error
+		if vmxFile, err = v.goldVMX(); err != nil {
+			return err
+		}
+	}
+	log.Printf("[DEBUG] Source virtual machine vmx file found %v", vmxFile)
 
 	// Gets VIX instance
 	client, err := v.client()
 	if err != nil {
-		return "", err
+		return err
 	}
 	defer client.Disconnect()
 
-	log.Printf("[INFO] Opening Gold virtual machine from %s", vmxFile)
+	log.Printf("[INFO] Opening source virtual machine from %s", vmxFile)
 
 	vm, err := client.OpenVM(vmxFile, v.Image.Password)
 
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Cloning source virtual machine into %s...", newvmx)
+	if v.SourceSnapshot != "" {
+		err = cloneSnapshot(vm, v.SourceSnapshot, v.CloneType, newvmx)
+	} else {
+		_, err = vm.Clone(v.CloneType, newvmx)
+	}
+
+	// If there is an error and the error is other than "The snapshot already exists"
+	// then return the error
+	if verr, ok := err.(*govix.Error); err != nil && (!ok || verr.Code != 13004) {
+		return err
+	}
+
+	return nil
+}
+
+// Either clones the virtual machine or creates it from scratch, then it
+// updates it.
+func (v *VM) Create() (string, error) {
+	log.Printf("[DEBUG] Creating VM resource...")
+
+	vmsPath, err := vmsDir()
 	if err != nil {
 		return "", err
 	}
 
-	baseVMDir := filepath.Join(usr.HomeDir, ".terraform", "vix", "vms",
-		image.Checksum, v.Name)
+	baseVMDir := filepath.Join(vmsPath, v.sourceID(), v.Name)
 
 	newvmx := filepath.Join(baseVMDir, v.Name+".vmx")
 
 	if _, err = os.Stat(newvmx); os.IsNotExist(err) {
-		log.Printf("[INFO] Virtual machine clone not found: %s, err: %+v", newvmx, err)
+		log.Printf("[INFO] Virtual machine not found: %s, err: %+v", newvmx, err)
 		// If there is not a VMX file, make sure nothing else is in there either.
 		// We were seeing VIX 13004 errors when only a nvram file existed.
 		os.RemoveAll(baseVMDir)
 
-		log.Printf("[INFO] Cloning Gold virtual machine into %s...", newvmx)
-		_, err := vm.Clone(govix.CLONETYPE_FULL, newvmx)
+		if v.fromScratch() {
+			err = v.createFromScratch(newvmx)
+		} else {
+			err = v.clone(newvmx)
+		}
 
-		// If there is an error and the error is other than "The snapshot already exists"
-		// then return the error
-		if err != nil && err.(*govix.Error).Code != 13004 {
+		if err != nil {
 			return "", err
 		}
 	} else {
-		log.Printf("[INFO] Virtual Machine clone %s already exist, moving on.", newvmx)
+		log.Printf("[INFO] Virtual Machine %s already exist, moving on.", newvmx)
 	}
 
+	v.creating = true
+	defer func() { v.creating = false }()
+
 	if err = v.Update(newvmx); err != nil {
 		return "", err
 	}
@@ -206,11 +389,16 @@ func (v *VM) Create() (string, error) {
 }
 
 // Opens and updates virtual machine resource
-func (v *VM) Update(vmxFile string) error {
+func (v *VM) Update(vmxFile string) (err error) {
 	// Sets default values if some attributes were not set or have
 	// invalid values
 	v.SetDefaults()
 
+	memoryInMb, err := ParseMemory(v.Memory)
+	if err != nil {
+		return err
+	}
+
 	// Gets VIX instance
 	client, err := v.client()
 	if err != nil {
@@ -248,16 +436,43 @@ func (v *VM) Update(vmxFile string) error {
 		}
 	}
 
-	memoryInMb, err := humanize.ParseBytes(v.Memory)
+	// Disks are grown before taking safe update snapshots, as disks with
+	// snapshots can not be grown.
+	if v.DiskSize != "" {
+		if err = v.growBootDisk(vmxFile); err != nil {
+			return err
+		}
+	}
+
+	// There is nothing to roll back to while creating the virtual machine
+	// The deferred function reads and replaces the named error, so it must not
+	// be shadowed from here on.
+	if v.SafeUpdate && !v.creating {
+		var safe *safeUpdate
+		if safe, err = v.beginSafeUpdate(vm, running); err != nil {
+			return err
+		}
+
+		defer func() {
+			err = safe.finish(err)
+		}()
+	}
+
+	// GoVMX drops keys it does not know about when rewriting the VMX file, so
+	// they are read beforehand in order to carry over unchanged values.
+	current, err := readVMX(vmxFile)
 	if err != nil {
-		log.Printf("[WARN] Unable to set memory size, defaulting to 512mib: %s", err)
-		memoryInMb = 512
-	} else {
-		memoryInMb = (memoryInMb / 1024) / 1024
+		return err
+	}
+
+	disks := append(append([]*Disk{}, v.Disks...), v.DetachedDisks...)
+	detached, err := detachDisks(vmxFile, disks)
+	if err != nil {
+		return err
 	}
 
 	log.Printf("[DEBUG] Setting memory size to %d megabytes", memoryInMb)
-	vm.SetMemorySize(uint(memoryInMb))
+	vm.SetMemorySize(memoryInMb)
 
 	log.Printf("[DEBUG] Setting vcpus to %d", v.CPUs)
 	vm.SetNumberVcpus(v.CPUs)
@@ -268,7 +483,11 @@ func (v *VM) Update(vmxFile string) error {
 	log.Printf("[DEBUG] Setting description to %s", v.Description)
 	vm.SetAnnotation(v.Description)
 
-	if v.UpgradeVHardware &&
+	if v.HardwareVersion > 0 {
+		if err = setHardwareVersion(vm, current, v.HardwareVersion); err != nil {
+			return err
+		}
+	} else if v.UpgradeVHardware &&
 		client.Provider != govix.VMWARE_PLAYER {
 
 		log.Println("[INFO] Upgrading virtual hardware...")
@@ -284,34 +503,90 @@ func (v *VM) Update(vmxFile string) error {
 		return err
 	}
 
+	if err = v.assignAutoStaticMACs(vmxFile); err != nil {
+		return err
+	}
+
 	log.Println("[INFO] Attaching virtual network adapters...")
 	for _, adapter := range v.VNetworkAdapters {
-		adapter.StartConnected = true
 		if adapter.ConnType == govix.NETWORK_BRIDGED {
 			adapter.LinkStatePropagation = true
 		}
 
+		// GoVIX is unable to plug adapters into custom vswitches as VSwitch IDs
+		// are private and ExistVSwitch is a stub. Custom adapters are added as
+		// host-only and plugged into their vswitch by attachVSwitches.
+		vnic := *adapter
+		if vnic.ConnType == govix.NETWORK_CUSTOM {
+			vnic.ConnType = govix.NETWORK_HOSTONLY
+		}
+
 		log.Printf("[DEBUG] Adapter: %+v", adapter)
-		err := vm.AddNetworkAdapter(adapter)
+		err := vm.AddNetworkAdapter(&vnic)
 		if err != nil {
 			return err
 		}
 	}
 
+	if err = v.attachVSwitches(vm); err != nil {
+		return err
+	}
+
 	log.Printf("[DEBUG] Removing all CD/DVD drives from vmx file...")
 	err = vm.RemoveAllCDDVDDrives()
 	if err != nil {
 		return err
 	}
 
+	var drives []*govix.CDDVDDrive
+	if v.BootISO != "" {
+		drives = append(drives, &govix.CDDVDDrive{Filename: v.BootISO})
+	}
+	drives = append(drives, v.CDDVDDrives...)
+
+	vmDir := filepath.Dir(vmxFile)
+	if v.CloudInit != nil {
+		seed, err := v.writeCloudInitISO(vmDir)
+		if err != nil {
+			return err
+		}
+		drives = append(drives, &govix.CDDVDDrive{Filename: seed})
+	} else if err = removeCloudInitISO(vmDir); err != nil {
+		return err
+	}
+
 	log.Println("[INFO] Attaching CD/DVD drives... ")
-	for _, cdrom := range v.CDDVDDrives {
+	for _, cdrom := range drives {
 		err := vm.AttachCDDVD(cdrom)
 		if err != nil {
 			return err
 		}
 	}
 
+	log.Println("[INFO] Attaching disks...")
+	if err = v.attachDisks(vmxFile, detached); err != nil {
+		return err
+	}
+
+	if err = v.writeBootSettings(vmxFile, current); err != nil {
+		return err
+	}
+
+	// GoVIX must not rewrite the VMX file from here on, GoVMX would drop the
+	// raw settings written below.
+	if err = v.writeTuning(vmxFile, current); err != nil {
+		return err
+	}
+
+	if err = v.writeVNC(vmxFile, current); err != nil {
+		return err
+	}
+
+	log.Println("[INFO] Writing guestinfo variables...")
+	if err = v.writeGuestInfo(vmxFile, current); err != nil {
+		return err
+	}
+
 	log.Println("[INFO] Powering virtual machine on...")
 	var options govix.VMPowerOption
 
@@ -328,26 +603,77 @@ func (v *VM) Update(vmxFile string) error {
 	}
 
 	log.Println("[INFO] Waiting for VMware Tools to initialize...")
-	err = vm.WaitForToolsInGuest(v.ToolsInitTimeout)
-	if err != nil {
+	toolsReady := true
+	if err = vm.WaitForToolsInGuest(v.ToolsInitTimeout); err != nil {
+		toolsReady = false
 		log.Println("[WARN] VMware Tools took too long to initialize or is not " +
 			"installed.")
 
-		if v.SharedFolders {
+		if v.managesShares() {
 			log.Println("[WARN] Enabling shared folders is not possible.")
 		}
-		return nil
 	}
 
-	if v.SharedFolders {
-		log.Println("[DEBUG] Enabling shared folders...")
+	if toolsReady {
+		if err = v.setUpSharedFolders(vm); err != nil {
+			return err
+		}
 
-		err = vm.EnableSharedFolders(v.SharedFolders)
-		if err != nil {
+		if err = v.uploadGuestFiles(vm, client); err != nil {
 			return err
 		}
+
+		if err = v.runGuestExecs(vm); err != nil {
+			return err
+		}
+	} else if len(v.GuestFiles) > 0 || v.pendingGuestExecs() {
+		return fmt.Errorf("[ERROR] Unable to upload guest files or run guest_exec " +
+			"blocks, VMware Tools is not running in the guest.")
 	}
-	return nil
+
+	if v.WaitForIP != nil {
+		log.Println("[INFO] Waiting for the guest to get an IP address...")
+		if v.IPAddress, err = v.waitForIP(vm); err != nil {
+			return err
+		}
+	}
+
+	// Files are only removed once the update succeeded, so safe updates can
+	// still roll back to snapshots using them.
+	return v.removeDetachedDisks(vmxFile)
+}
+
+// Plugs custom network adapters into their virtual switches, writing
+// ethernetN.vnet straight into the VMX file.
+func (v *VM) attachVSwitches(vm *govix.VM) error {
+	vmxFile, err := vm.VmxPath()
+	if err != nil {
+		return err
+	}
+
+	return updateVMX(vmxFile, func(vmx map[string]string) error {
+		adapters, _ := networkAdapters(vmx)
+		for i, adapter := range adapters {
+			if i >= len(v.VNetworkAdapters) || i >= len(v.VSwitches) {
+				break
+			}
+
+			if v.VNetworkAdapters[i].ConnType != govix.NETWORK_CUSTOM {
+				continue
+			}
+
+			name, err := VSwitchName(v.VSwitches[i])
+			if err != nil {
+				return err
+			}
+
+			log.Printf("[DEBUG] Plugging ethernet%s into %s", adapter.ID, name)
+			prefix := "ethernet" + adapter.ID + "."
+			vmx[prefix+"connectiontype"] = string(govix.NETWORK_CUSTOM)
+			vmx[prefix+"vnet"] = vnetValue(name)
+		}
+		return nil
+	})
 }
 
 // Powers off a virtual machine attempting a graceful shutdown.
@@ -448,15 +774,59 @@ func (v *VM) Refresh(vmxFile string) (bool, error) {
 		return running, err
 	}
 
-	// We need to convert memory value to megabytes so humanize can interpret it
-	// properly.
-	memory = (memory * 1024) * 1024
-	v.Memory = strings.ToLower(humanize.IBytes(uint64(memory)))
+	// Reported in megabytes, humanize would round sizes such as 1100mib
+	v.Memory = fmt.Sprintf("%dmib", memory)
 	v.CPUs = uint(vcpus)
 	v.Name, err = vm.DisplayName()
 	v.Description, err = vm.Annotation()
-	v.VNetworkAdapters, err = vm.NetworkAdapters()
-	v.IPAddress, err = vm.IPAddress()
+	if err = v.readNetworkAdapters(vmxFile); err != nil {
+		return running, err
+	}
+
+	if len(v.Disks) > 0 {
+		if err = v.readDisks(vmxFile); err != nil {
+			return running, err
+		}
+	}
+
+	if v.managesShares() {
+		if err = v.readSharedFolders(vm); err != nil {
+			log.Printf("[WARN] Unable to read shared folders: %s", err)
+		}
+	}
+
+	vmx, err := readVMX(vmxFile)
+	if err != nil {
+		return running, err
+	}
+
+	v.GuestOS = vmx["guestos"]
+	v.HardwareVersion, _ = strconv.Atoi(vmx["virtualhw.version"])
+	v.Firmware = vmx["firmware"]
+	if v.Firmware == "" {
+		v.Firmware = "bios"
+	}
+	v.SecureBoot = strings.EqualFold(vmx["uefi.secureboot.enabled"], "true")
+	v.Tuning = readTuning(vmx)
+	v.VNC = readVNC(vmx)
+
+	v.IPAddress, err = v.guestIP(vm)
 
 	return running, err
 }
+
+// Pins the virtual hardware version, it has to be set before attaching
+// devices requiring it, such as vmxnet3 adapters.
+func setHardwareVersion(vm *govix.VM, current map[string]string, version int) error {
+	existing, _ := strconv.Atoi(current["virtualhw.version"])
+	if existing == version {
+		return nil
+	}
+
+	if version < existing {
+		return fmt.Errorf("[ERROR] Unable to downgrade virtual hardware from version %d to %d", existing, version)
+	}
+
+	log.Printf("[INFO] Setting virtual hardware version to %d...", version)
+	return vm.SetVirtualHwVersion(strconv.Itoa(version))
+}
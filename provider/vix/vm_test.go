@@ -0,0 +1,21 @@
+package vix
+
+import "testing"
+
+func TestParseMemory(t *testing.T) {
+	for size, expected := range map[string]uint{
+		"512mib":  512,
+		"1gib":    1024,
+		"1.0 gib": 1024,
+		"1100mib": 1100,
+	} {
+		memory, err := ParseMemory(size)
+		ok(t, err)
+		equals(t, expected, memory)
+	}
+
+	for _, size := range []string{"", "lots", "0", "1gb", "513mib"} {
+		_, err := ParseMemory(size)
+		assert(t, err != nil, "%q should not be a valid memory size", size)
+	}
+}
@@ -0,0 +1,550 @@
+package vix
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/dustin/go-humanize"
+)
+
+// Hosted sparse extent layout, as described in VMware's Virtual Disk Format
+// 1.1 specification. Sizes and offsets are in sectors.
+const (
+	sectorSize           = 512
+	vmdkMagic            = 0x564d444b // KDMV
+	vmdkGrainSize        = 128        // 64KiB
+	vmdkGTEsPerGT        = 512
+	vmdkDescriptorOffset = 1
+	vmdkDescriptorSize   = 20
+	// Valid new line detection test and redundant grain table
+	vmdkFlags = 0x3
+	// Flags of extents with a redundant grain table and compressed grains
+	vmdkFlagRedundantGT = 0x2
+	vmdkFlagCompressed  = 0x10000
+	// Maximum size of the extents of twoGbMaxExtentSparse disks
+	vmdkMaxSplitExtent = 4192256
+)
+
+// Header at the beginning of sparse extents
+type sparseExtentHeader struct {
+	MagicNumber        uint32
+	Version            uint32
+	Flags              uint32
+	Capacity           uint64
+	GrainSize          uint64
+	DescriptorOffset   uint64
+	DescriptorSize     uint64
+	NumGTEsPerGT       uint32
+	RGDOffset          uint64
+	GDOffset           uint64
+	OverHead           uint64
+	UncleanShutdown    uint8
+	SingleEndLineChar  byte
+	NonEndLineChar     byte
+	DoubleEndLineChar1 byte
+	DoubleEndLineChar2 byte
+	CompressAlgorithm  uint16
+	Pad                [433]byte
+}
+
+// Number of sectors covered by each grain table
+const vmdkGTCoverage = vmdkGrainSize * vmdkGTEsPerGT
+
+// Rounds n up to the next multiple of m
+func roundUp(n, m uint64) uint64 {
+	return (n + m - 1) / m * m
+}
+
+// Lays out a sparse extent of capacity sectors: the embedded descriptor, if
+// any, the redundant grain directory and tables, then the grain directory and
+// tables. Grain tables are preallocated, as vmware-vdiskmanager does, and
+// grains go after the overhead.
+func newSparseExtentHeader(capacity uint64, embedDescriptor bool) *sparseExtentHeader {
+	h := &sparseExtentHeader{
+		MagicNumber:        vmdkMagic,
+		Version:            1,
+		Flags:              vmdkFlags,
+		Capacity:           capacity,
+		GrainSize:          vmdkGrainSize,
+		NumGTEsPerGT:       vmdkGTEsPerGT,
+		SingleEndLineChar:  '\n',
+		NonEndLineChar:     ' ',
+		DoubleEndLineChar1: '\r',
+		DoubleEndLineChar2: '\n',
+	}
+
+	h.RGDOffset = 1
+	if embedDescriptor {
+		h.DescriptorOffset = vmdkDescriptorOffset
+		h.DescriptorSize = vmdkDescriptorSize
+		h.RGDOffset = vmdkDescriptorOffset + vmdkDescriptorSize
+	}
+
+	tables := h.gdSectors() + h.gtSectors()
+	h.GDOffset = h.RGDOffset + tables
+	h.OverHead = roundUp(h.GDOffset+tables, vmdkGrainSize)
+
+	return h
+}
+
+// Number of grain tables, and therefore grain directory entries, of the extent
+func (h *sparseExtentHeader) numGTs() uint64 {
+	coverage := h.GrainSize * uint64(h.NumGTEsPerGT)
+	return roundUp(h.Capacity, coverage) / coverage
+}
+
+// Size of the grain directory in sectors
+func (h *sparseExtentHeader) gdSectors() uint64 {
+	return roundUp(h.numGTs()*4, sectorSize) / sectorSize
+}
+
+// Size of a grain table in sectors
+func (h *sparseExtentHeader) gtSize() uint64 {
+	return uint64(h.NumGTEsPerGT) * 4 / sectorSize
+}
+
+// Size of all the grain tables in sectors
+func (h *sparseExtentHeader) gtSectors() uint64 {
+	return h.numGTs() * h.gtSize()
+}
+
+// Builds a grain directory pointing to the grain tables laid out right after
+// it. Entries of the first len(existing) grain tables are taken from existing
+// instead, so they keep pointing to the tables already in use.
+func (h *sparseExtentHeader) grainDirectory(gdOffset uint64, existing []uint32) []byte {
+	gd := make([]byte, h.gdSectors()*sectorSize)
+
+	gtOffset := gdOffset + h.gdSectors()
+	for i := uint64(0); i < h.numGTs(); i++ {
+		if i < uint64(len(existing)) {
+			binary.LittleEndian.PutUint32(gd[i*4:], existing[i])
+			continue
+		}
+
+		binary.LittleEndian.PutUint32(gd[i*4:], uint32(gtOffset))
+		gtOffset += h.gtSize()
+	}
+
+	return gd
+}
+
+// Disk geometry VMware reports to the guest BIOS
+func vmdkGeometry(capacity uint64, adapterType string) (cylinders, heads, sectors uint64) {
+	heads, sectors = 255, 63
+	if adapterType == "ide" {
+		heads = 16
+	}
+
+	cylinders = capacity / (heads * sectors)
+	if adapterType == "ide" && cylinders > 16383 {
+		cylinders = 16383
+	}
+
+	return cylinders, heads, sectors
+}
+
+// Builds the text descriptor of a single extent disk, either monolithicSparse
+// or streamOptimized.
+func newVMDKDescriptor(createType, extent string, capacity uint64, adapterType string, hwVersion int) (string, error) {
+	cid := make([]byte, 4)
+	if _, err := rand.Read(cid); err != nil {
+		return "", err
+	}
+
+	cylinders, heads, sectors := vmdkGeometry(capacity, adapterType)
+
+	var buf bytes.Buffer
+	buf.WriteString("# Disk DescriptorFile\n")
+	buf.WriteString("version=1\n")
+	buf.WriteString("encoding=\"UTF-8\"\n")
+	buf.WriteString("CID=" + hex.EncodeToString(cid) + "\n")
+	buf.WriteString("parentCID=ffffffff\n")
+	buf.WriteString("createType=\"" + createType + "\"\n\n")
+	buf.WriteString("# Extent description\n")
+	buf.WriteString(fmt.Sprintf("RW %d SPARSE \"%s\"\n\n", capacity, extent))
+	buf.WriteString("# The Disk Data Base\n")
+	buf.WriteString("#DDB\n\n")
+	buf.WriteString(fmt.Sprintf("ddb.virtualHWVersion = \"%d\"\n", hwVersion))
+	buf.WriteString(fmt.Sprintf("ddb.geometry.cylinders = \"%d\"\n", cylinders))
+	buf.WriteString(fmt.Sprintf("ddb.geometry.heads = \"%d\"\n", heads))
+	buf.WriteString(fmt.Sprintf("ddb.geometry.sectors = \"%d\"\n", sectors))
+	buf.WriteString(fmt.Sprintf("ddb.adapterType = \"%s\"\n", adapterType))
+
+	return buf.String(), nil
+}
+
+// Writes an empty sparse extent of capacity sectors to path, embedding
+// descriptor if it is not empty. Only the header, descriptor and grain
+// directories are written, grain tables are left as holes in the file.
+func writeSparseExtent(path string, capacity uint64, descriptor string) error {
+	header := newSparseExtentHeader(capacity, descriptor != "")
+
+	if len(descriptor) > int(header.DescriptorSize*sectorSize) {
+		return fmt.Errorf("[ERROR] Disk descriptor is too large: %d bytes", len(descriptor))
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err = binary.Write(file, binary.LittleEndian, header); err != nil {
+		return err
+	}
+
+	if _, err = file.WriteAt([]byte(descriptor), int64(header.DescriptorOffset*sectorSize)); err != nil {
+		return err
+	}
+
+	for _, offset := range []uint64{header.RGDOffset, header.GDOffset} {
+		if _, err = file.WriteAt(header.grainDirectory(offset, nil), int64(offset*sectorSize)); err != nil {
+			return err
+		}
+	}
+
+	return file.Truncate(int64(header.OverHead * sectorSize))
+}
+
+// Creates an empty monolithic sparse disk of at least size bytes. adapterType
+// is either ide, buslogic or lsilogic.
+func createSparseVMDK(path string, size uint64, adapterType string, hwVersion int) error {
+	if size == 0 {
+		return fmt.Errorf("[ERROR] Disk size has to be greater than zero: %s", path)
+	}
+
+	// Rounds capacity up to whole megabytes, as VMware does
+	capacity := roundUp(size, 1024*1024) / sectorSize
+
+	descriptor, err := newVMDKDescriptor("monolithicSparse", filepath.Base(path), capacity, adapterType, hwVersion)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("[DEBUG] Creating %s sparse disk %s", humanize.IBytes(capacity*sectorSize), path)
+
+	return writeSparseExtent(path, capacity, descriptor)
+}
+
+// Converts size bytes of disk data read from src into a monolithic sparse
+// disk. Grains are appended after the overhead as they are read, all-zero
+// grains are left unallocated.
+func convertToSparseVMDK(path string, src io.ReaderAt, size uint64, adapterType string, hwVersion int) error {
+	if size == 0 {
+		return fmt.Errorf("[ERROR] Disk size has to be greater than zero: %s", path)
+	}
+
+	capacity := roundUp(size, sectorSize) / sectorSize
+
+	descriptor, err := newVMDKDescriptor("monolithicSparse", filepath.Base(path), capacity, adapterType, hwVersion)
+	if err != nil {
+		return err
+	}
+
+	if err = writeSparseExtent(path, capacity, descriptor); err != nil {
+		return err
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	header := newSparseExtentHeader(capacity, true)
+
+	log.Printf("[DEBUG] Converting %s of disk data into %s", humanize.IBytes(size), path)
+
+	gtes := make([]uint32, header.numGTs()*uint64(header.NumGTEsPerGT))
+	grain := make([]byte, header.GrainSize*sectorSize)
+	zero := make([]byte, len(grain))
+	next := header.OverHead
+
+	for i := range gtes {
+		offset := int64(uint64(i) * uint64(len(grain)))
+		if uint64(offset) >= size {
+			break
+		}
+
+		n, err := src.ReadAt(grain, offset)
+		if err != nil && err != io.EOF {
+			return err
+		}
+		copy(grain[n:], zero)
+
+		if bytes.Equal(grain, zero) {
+			continue
+		}
+
+		if _, err = file.WriteAt(grain, int64(next*sectorSize)); err != nil {
+			return err
+		}
+		gtes[i] = uint32(next)
+		next += header.GrainSize
+	}
+
+	// Both grain directories point to their own copy of the grain tables
+	tables := make([]byte, len(gtes)*4)
+	for i, gte := range gtes {
+		binary.LittleEndian.PutUint32(tables[i*4:], gte)
+	}
+
+	for _, offset := range []uint64{header.RGDOffset, header.GDOffset} {
+		if _, err = file.WriteAt(tables, int64((offset+header.gdSectors())*sectorSize)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Extent lines of VMDK descriptors, ie: RW 4192256 SPARSE "disk-s001.vmdk"
+var vmdkExtentRegexp = regexp.MustCompile(`(?m)^(RW|RDONLY|NOACCESS)[ \t]+(\d+)[ \t]+(\w+)[ \t]+"([^"]+)".*$`)
+
+// Reads the text descriptor of a disk, either embedded into a sparse extent
+// or standalone, along with the header of the extent embedding it, if any.
+func readVMDKDescriptor(path string) (string, *sparseExtentHeader, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", nil, err
+	}
+	defer file.Close()
+
+	buf := make([]byte, sectorSize)
+	n, err := io.ReadFull(file, buf)
+	if err != nil && err != io.ErrUnexpectedEOF {
+		return "", nil, err
+	}
+
+	if n < sectorSize || binary.LittleEndian.Uint32(buf) != vmdkMagic {
+		// Standalone descriptors are small text files
+		rest, err := ioutil.ReadAll(io.LimitReader(file, 64*1024))
+		if err != nil {
+			return "", nil, err
+		}
+		return string(buf[:n]) + string(rest), nil, nil
+	}
+
+	header := new(sparseExtentHeader)
+	if err = binary.Read(bytes.NewReader(buf), binary.LittleEndian, header); err != nil {
+		return "", nil, err
+	}
+
+	if header.DescriptorSize == 0 {
+		return "", nil, fmt.Errorf("[ERROR] %s is an extent of another disk, it has no descriptor", path)
+	}
+
+	descriptor := make([]byte, header.DescriptorSize*sectorSize)
+	if _, err = file.ReadAt(descriptor, int64(header.DescriptorOffset*sectorSize)); err != nil {
+		return "", nil, err
+	}
+
+	return string(bytes.TrimRight(descriptor, "\x00")), header, nil
+}
+
+// Looks a key up in a VMDK descriptor
+func descriptorValue(descriptor, key string) string {
+	re := regexp.MustCompile(`(?mi)^` + regexp.QuoteMeta(key) + `[ \t]*=[ \t]*"?([^"\r\n]*)"?`)
+	if m := re.FindStringSubmatch(descriptor); m != nil {
+		return strings.TrimSpace(m[1])
+	}
+	return ""
+}
+
+// Sets the value of a key already in a VMDK descriptor
+func setDescriptorValue(descriptor, key, value string) string {
+	re := regexp.MustCompile(`(?mi)^(` + regexp.QuoteMeta(key) + `[ \t]*=[ \t]*)"?[^"\r\n]*"?`)
+	return re.ReplaceAllString(descriptor, "${1}\""+value+"\"")
+}
+
+// Capacity of a disk in sectors, as the sum of its extents
+func vmdkCapacity(descriptor string) uint64 {
+	var capacity uint64
+	for _, extent := range vmdkExtentRegexp.FindAllStringSubmatch(descriptor, -1) {
+		sectors, _ := strconv.ParseUint(extent[2], 10, 64)
+		capacity += sectors
+	}
+	return capacity
+}
+
+// Updates the geometry in the descriptor of a disk grown to capacity sectors
+func resizeVMDKDescriptor(descriptor string, capacity uint64) string {
+	cylinders, _, _ := vmdkGeometry(capacity, descriptorValue(descriptor, "ddb.adapterType"))
+	return setDescriptorValue(descriptor, "ddb.geometry.cylinders", strconv.FormatUint(cylinders, 10))
+}
+
+// Finds vmware-vdiskmanager, which ships with Workstation and Fusion
+func vdiskManager() string {
+	if path, err := exec.LookPath("vmware-vdiskmanager"); err == nil {
+		return path
+	}
+
+	fusion := "/Applications/VMware Fusion.app/Contents/Library/vmware-vdiskmanager"
+	if _, err := os.Stat(fusion); err == nil {
+		return fusion
+	}
+
+	return ""
+}
+
+// Grows a disk to at least size bytes, it is left untouched if it is that
+// large already. vmware-vdiskmanager is used if it is available, otherwise
+// monolithic and split sparse disks are grown by rewriting their descriptor
+// and grain directories.
+func growVMDK(path string, size uint64) error {
+	descriptor, header, err := readVMDKDescriptor(path)
+	if err != nil {
+		return err
+	}
+
+	capacity := roundUp(size, 1024*1024) / sectorSize
+	current := vmdkCapacity(descriptor)
+	if capacity <= current {
+		log.Printf("[DEBUG] Disk %s is %s already", path, humanize.IBytes(current*sectorSize))
+		return nil
+	}
+
+	// Parents of delta disks would end up smaller than their children
+	if parent := descriptorValue(descriptor, "parentCID"); parent != "" && !strings.EqualFold(parent, "ffffffff") {
+		return fmt.Errorf("[ERROR] Unable to grow %s, disks of linked clones or "+
+			"with snapshots can not be grown", path)
+	}
+
+	log.Printf("[INFO] Growing disk %s from %s to %s", path,
+		humanize.IBytes(current*sectorSize), humanize.IBytes(capacity*sectorSize))
+
+	if tool := vdiskManager(); tool != "" {
+		megabytes := capacity * sectorSize / 1024 / 1024
+		output, err := exec.Command(tool, "-x", fmt.Sprintf("%dMB", megabytes), path).CombinedOutput()
+		if err != nil {
+			return fmt.Errorf("[ERROR] Unable to grow %s with vmware-vdiskmanager: %s: %s", path, err, output)
+		}
+		return nil
+	}
+
+	createType := descriptorValue(descriptor, "createType")
+	switch {
+	case header != nil && createType == "monolithicSparse":
+		return growMonolithicSparse(path, header, descriptor, capacity)
+	case header == nil && createType == "twoGbMaxExtentSparse":
+		return growSplitSparse(path, descriptor, capacity)
+	default:
+		return fmt.Errorf("[ERROR] Unable to grow %s disks without vmware-vdiskmanager: %s", createType, path)
+	}
+}
+
+// Grows a monolithic sparse disk in place. If more grain tables are needed,
+// new grain directories are appended to the file, pointing to the existing
+// grain tables followed by new ones.
+func growMonolithicSparse(path string, header *sparseExtentHeader, descriptor string, capacity uint64) error {
+	if header.Flags&vmdkFlagCompressed != 0 {
+		return fmt.Errorf("[ERROR] Unable to grow %s, compressed disks are read-only", path)
+	}
+
+	descriptor = vmdkExtentRegexp.ReplaceAllString(descriptor, fmt.Sprintf(`${1} %d ${3} "${4}"`, capacity))
+	descriptor = resizeVMDKDescriptor(descriptor, capacity)
+
+	embedded := make([]byte, header.DescriptorSize*sectorSize)
+	if copy(embedded, descriptor) < len(descriptor) {
+		return fmt.Errorf("[ERROR] Disk descriptor is too large: %d bytes", len(descriptor))
+	}
+
+	file, err := os.OpenFile(path, os.O_RDWR, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	grown := *header
+	grown.Capacity = capacity
+
+	if grown.numGTs() > header.numGTs() {
+		info, err := file.Stat()
+		if err != nil {
+			return err
+		}
+		end := roundUp(uint64(info.Size()), sectorSize) / sectorSize
+
+		directories := []*uint64{&grown.GDOffset}
+		if header.Flags&vmdkFlagRedundantGT != 0 {
+			directories = append(directories, &grown.RGDOffset)
+		}
+
+		for _, offset := range directories {
+			existing := make([]uint32, header.numGTs())
+			section := io.NewSectionReader(file, int64(*offset*sectorSize), int64(len(existing)*4))
+			if err = binary.Read(section, binary.LittleEndian, existing); err != nil {
+				return err
+			}
+
+			if _, err = file.WriteAt(grown.grainDirectory(end, existing), int64(end*sectorSize)); err != nil {
+				return err
+			}
+
+			*offset = end
+			end += grown.gdSectors() + (grown.numGTs()-header.numGTs())*grown.gtSize()
+		}
+
+		// New grain tables are left as holes, VMware allocates grains after them
+		if err = file.Truncate(int64(end * sectorSize)); err != nil {
+			return err
+		}
+	}
+
+	if _, err = file.WriteAt(embedded, int64(header.DescriptorOffset*sectorSize)); err != nil {
+		return err
+	}
+
+	if _, err = file.Seek(0, io.SeekStart); err != nil {
+		return err
+	}
+
+	return binary.Write(file, binary.LittleEndian, &grown)
+}
+
+// Grows a split sparse disk adding extents to its descriptor
+func growSplitSparse(path string, descriptor string, capacity uint64) error {
+	extents := vmdkExtentRegexp.FindAllStringSubmatchIndex(descriptor, -1)
+	if len(extents) == 0 {
+		return fmt.Errorf("[ERROR] No extents found in %s", path)
+	}
+
+	dir := filepath.Dir(path)
+	base := strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+
+	var lines []string
+	remaining := capacity - vmdkCapacity(descriptor)
+	for n := len(extents) + 1; remaining > 0; n++ {
+		sectors := remaining
+		if sectors > vmdkMaxSplitExtent {
+			sectors = vmdkMaxSplitExtent
+		}
+
+		name := fmt.Sprintf("%s-s%03d.vmdk", base, n)
+		log.Printf("[DEBUG] Adding extent %s to %s", name, path)
+		if err := writeSparseExtent(filepath.Join(dir, name), sectors, ""); err != nil {
+			return err
+		}
+
+		lines = append(lines, fmt.Sprintf(`RW %d SPARSE "%s"`, sectors, name))
+		remaining -= sectors
+	}
+
+	last := extents[len(extents)-1][1]
+	descriptor = descriptor[:last] + "\n" + strings.Join(lines, "\n") + descriptor[last:]
+	descriptor = resizeVMDKDescriptor(descriptor, capacity)
+
+	return ioutil.WriteFile(path, []byte(descriptor), 0644)
+}
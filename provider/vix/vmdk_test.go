@@ -0,0 +1,274 @@
+package vix
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCreateSparseVMDK(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "terraform-vix")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "disk.vmdk")
+	ok(t, createSparseVMDK(path, 40*1024*1024*1024+1, "lsilogic", 11))
+
+	file, err := os.Open(path)
+	ok(t, err)
+	defer file.Close()
+
+	header := new(sparseExtentHeader)
+	ok(t, binary.Read(file, binary.LittleEndian, header))
+	equals(t, uint32(vmdkMagic), header.MagicNumber)
+	equals(t, uint64(40*1024*1024*2+2048), header.Capacity)
+	equals(t, uint64(0), header.OverHead%vmdkGrainSize)
+
+	info, err := file.Stat()
+	ok(t, err)
+	equals(t, int64(header.OverHead*sectorSize), info.Size())
+
+	descriptor := make([]byte, vmdkDescriptorSize*sectorSize)
+	_, err = file.ReadAt(descriptor, vmdkDescriptorOffset*sectorSize)
+	ok(t, err)
+	assert(t, strings.Contains(string(descriptor), `createType="monolithicSparse"`), "invalid descriptor: %s", descriptor)
+	assert(t, strings.Contains(string(descriptor), `RW 83888128 SPARSE "disk.vmdk"`), "invalid extent: %s", descriptor)
+	assert(t, strings.Contains(string(descriptor), `ddb.geometry.heads = "255"`), "invalid geometry: %s", descriptor)
+
+	// Both grain directories point to their own grain tables, which do not
+	// overlap each other
+	numGTs := header.numGTs()
+	equals(t, uint64(1281), numGTs)
+	for _, gdOffset := range []uint64{header.RGDOffset, header.GDOffset} {
+		gd := make([]byte, numGTs*4)
+		_, err = file.ReadAt(gd, int64(gdOffset*sectorSize))
+		ok(t, err)
+
+		first := uint64(binary.LittleEndian.Uint32(gd))
+		last := uint64(binary.LittleEndian.Uint32(gd[len(gd)-4:]))
+		equals(t, gdOffset+header.gdSectors(), first)
+		assert(t, last+4 <= header.OverHead, "grain table %d is out of bounds", last)
+	}
+	assert(t, header.RGDOffset+header.gdSectors()+header.gtSectors() <= header.GDOffset,
+		"grain tables overlap")
+
+	err = createSparseVMDK(path, 1024, "ide", 11)
+	assert(t, os.IsExist(err), "existing disks must not be overwritten: %v", err)
+}
+
+func TestVMDKGeometry(t *testing.T) {
+	cylinders, heads, sectors := vmdkGeometry(40*1024*1024*2, "lsilogic")
+	equals(t, uint64(5221), cylinders)
+	equals(t, uint64(255), heads)
+	equals(t, uint64(63), sectors)
+
+	cylinders, heads, _ = vmdkGeometry(40*1024*1024*2, "ide")
+	equals(t, uint64(16383), cylinders)
+	equals(t, uint64(16), heads)
+}
+
+func readSparseExtentHeader(t *testing.T, path string) *sparseExtentHeader {
+	file, err := os.Open(path)
+	ok(t, err)
+	defer file.Close()
+
+	header := new(sparseExtentHeader)
+	ok(t, binary.Read(file, binary.LittleEndian, header))
+	return header
+}
+
+func TestGrowMonolithicSparse(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "terraform-vix")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "disk.vmdk")
+	ok(t, createSparseVMDK(path, 1024*1024*1024, "lsilogic", 11))
+	before := readSparseExtentHeader(t, path)
+
+	// Disks are never shrunk
+	ok(t, growVMDK(path, 512*1024*1024))
+	equals(t, before, readSparseExtentHeader(t, path))
+
+	ok(t, growVMDK(path, 64*1024*1024*1024))
+	after := readSparseExtentHeader(t, path)
+	equals(t, uint64(64*1024*1024*2), after.Capacity)
+	equals(t, uint64(2048), after.numGTs())
+	equals(t, before.OverHead, after.OverHead)
+	assert(t, after.RGDOffset >= before.OverHead, "redundant grain directory was not moved")
+	assert(t, after.GDOffset >= before.OverHead, "grain directory was not moved")
+
+	descriptor, _, err := readVMDKDescriptor(path)
+	ok(t, err)
+	equals(t, after.Capacity, vmdkCapacity(descriptor))
+	equals(t, "8354", descriptorValue(descriptor, "ddb.geometry.cylinders"))
+
+	file, err := os.Open(path)
+	ok(t, err)
+	defer file.Close()
+
+	// Existing grain tables are kept, new ones follow the grain directory
+	gd := make([]uint32, after.numGTs())
+	ok(t, binary.Read(io.NewSectionReader(file, int64(after.GDOffset*sectorSize), int64(len(gd)*4)),
+		binary.LittleEndian, gd))
+	equals(t, uint32(before.GDOffset+before.gdSectors()), gd[0])
+	equals(t, uint32(after.GDOffset+after.gdSectors()), gd[before.numGTs()])
+
+	// New grain tables of the last grain directory end the file
+	last := after.GDOffset
+	if after.RGDOffset > last {
+		last = after.RGDOffset
+	}
+	end := last + after.gdSectors() + (after.numGTs()-before.numGTs())*after.gtSize()
+
+	info, err := file.Stat()
+	ok(t, err)
+	equals(t, int64(end*sectorSize), info.Size())
+}
+
+func TestGrowSplitSparse(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "terraform-vix")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+
+	ok(t, writeSparseExtent(filepath.Join(dir, "disk-s001.vmdk"), vmdkMaxSplitExtent, ""))
+
+	path := filepath.Join(dir, "disk.vmdk")
+	ok(t, ioutil.WriteFile(path, []byte(`# Disk DescriptorFile
+version=1
+CID=fffffffe
+parentCID=ffffffff
+createType="twoGbMaxExtentSparse"
+
+# Extent description
+RW 4192256 SPARSE "disk-s001.vmdk"
+
+# The Disk Data Base
+#DDB
+
+ddb.adapterType = "lsilogic"
+ddb.geometry.cylinders = "261"
+ddb.geometry.heads = "255"
+ddb.geometry.sectors = "63"
+`), 0644))
+
+	ok(t, growVMDK(path, 5*1024*1024*1024))
+
+	descriptor, header, err := readVMDKDescriptor(path)
+	ok(t, err)
+	assert(t, header == nil, "descriptor is not embedded")
+	equals(t, uint64(5*1024*1024*2), vmdkCapacity(descriptor))
+	assert(t, strings.Contains(descriptor, "RW 4192256 SPARSE \"disk-s001.vmdk\"\n"+
+		"RW 4192256 SPARSE \"disk-s002.vmdk\"\n"+
+		"RW 2101248 SPARSE \"disk-s003.vmdk\"\n"), "invalid extents: %s", descriptor)
+
+	equals(t, uint64(2101248), readSparseExtentHeader(t, filepath.Join(dir, "disk-s003.vmdk")).Capacity)
+}
+
+func TestGrowLinkedClone(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "terraform-vix")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "disk-000001.vmdk")
+	ok(t, ioutil.WriteFile(path, []byte(`version=1
+CID=fffffffe
+parentCID=fffffffd
+createType="monolithicSparse"
+RW 2097152 SPARSE "disk-000001.vmdk"
+`), 0644))
+
+	assert(t, growVMDK(path, 2*1024*1024*1024) != nil, "delta disks can not be grown")
+}
+
+// Disk data with a couple of grains set, the rest are zeros
+func testDiskData(size int, grains ...int) []byte {
+	data := make([]byte, size)
+	for _, grain := range grains {
+		copy(data[grain*vmdkGrainSize*sectorSize:], strings.Repeat("vix", 1000))
+	}
+	return data
+}
+
+func readAll(t *testing.T, r io.ReaderAt, size uint64) []byte {
+	data := make([]byte, size)
+	_, err := r.ReadAt(data, 0)
+	ok(t, err)
+	return data
+}
+
+func TestReadVMDKChain(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "terraform-vix")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+
+	const size = 4 * 1024 * 1024
+	base := testDiskData(size, 0, 3)
+	basePath := filepath.Join(dir, "disk.vmdk")
+	ok(t, convertToSparseVMDK(basePath, bytes.NewReader(base), size, "lsilogic", 11))
+
+	disk, err := openVMDK(basePath)
+	ok(t, err)
+	equals(t, uint64(size), disk.Size())
+	equals(t, base, readAll(t, disk, size))
+	ok(t, disk.Close())
+
+	// Deltas only hold the grains written after the snapshot was taken
+	delta := testDiskData(size, 5)
+	deltaPath := filepath.Join(dir, "disk-000001.vmdk")
+	ok(t, convertToSparseVMDK(deltaPath, bytes.NewReader(delta), size, "lsilogic", 11))
+
+	descriptor, header, err := readVMDKDescriptor(deltaPath)
+	ok(t, err)
+	descriptor = strings.Replace(descriptor, "parentCID=ffffffff", "parentCID=fffffffe\n"+
+		`parentFileNameHint="disk.vmdk"`, 1)
+
+	file, err := os.OpenFile(deltaPath, os.O_RDWR, 0644)
+	ok(t, err)
+	_, err = file.WriteAt([]byte(descriptor), int64(header.DescriptorOffset*sectorSize))
+	ok(t, err)
+	ok(t, file.Close())
+
+	disk, err = openVMDK(deltaPath)
+	ok(t, err)
+	defer disk.Close()
+
+	expected := testDiskData(size, 0, 3, 5)
+	equals(t, expected, readAll(t, disk, size))
+}
+
+func TestStreamOptimizedVMDK(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "terraform-vix")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+
+	const size = 64 * 1024 * 1024
+	data := testDiskData(size, 1, 600)
+
+	path := filepath.Join(dir, "disk.vmdk")
+	ok(t, writeStreamOptimizedVMDK(path, bytes.NewReader(data), size, "lsilogic", 11))
+
+	header := readSparseExtentHeader(t, path)
+	equals(t, uint32(vmdkStreamFlags), header.Flags)
+	equals(t, vmdkGDAtEnd, header.GDOffset)
+
+	descriptor, _, err := readVMDKDescriptor(path)
+	ok(t, err)
+	equals(t, "streamOptimized", descriptorValue(descriptor, "createType"))
+
+	// Streams end with an end of stream marker, an empty sector
+	raw, err := ioutil.ReadFile(path)
+	ok(t, err)
+	equals(t, make([]byte, sectorSize), raw[len(raw)-sectorSize:])
+	assert(t, len(raw) < size/100, "grains should have been compressed: %d bytes", len(raw))
+
+	disk, err := openVMDK(path)
+	ok(t, err)
+	defer disk.Close()
+	equals(t, data, readAll(t, disk, size))
+}
@@ -0,0 +1,302 @@
+package vix
+
+import (
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Grain table entries with special meaning: the grain is either to be read
+// from the parent disk, or it reads as zeros.
+const (
+	vmdkGTEUnallocated = 0
+	vmdkGTEZero        = 1
+)
+
+// Extent of a disk, mapping sectors start through start+sectors of the disk
+// to a file.
+type vmdkExtent struct {
+	start   uint64
+	sectors uint64
+	// SPARSE, FLAT, VMFS or ZERO
+	kind   string
+	file   *os.File
+	offset uint64
+	// Header and grain directory of sparse extents
+	header *sparseExtentHeader
+	gd     []uint32
+	// Last grain table read, disks are read sequentially
+	gt       []uint32
+	gtOffset uint32
+}
+
+// Reads the guest data of a disk, following its chain of parents for grains
+// not allocated in delta disks. Disks have to be closed once done with them.
+type vmdkReader struct {
+	extents  []*vmdkExtent
+	parent   *vmdkReader
+	capacity uint64
+}
+
+// Opens a disk along with its parents
+func openVMDK(path string) (*vmdkReader, error) {
+	descriptor, _, err := readVMDKDescriptor(path)
+	if err != nil {
+		return nil, err
+	}
+
+	disk := &vmdkReader{}
+	dir := filepath.Dir(path)
+
+	for _, m := range vmdkExtentRegexp.FindAllStringSubmatch(descriptor, -1) {
+		sectors, _ := strconv.ParseUint(m[2], 10, 64)
+		extent := &vmdkExtent{start: disk.capacity, sectors: sectors, kind: strings.ToUpper(m[3])}
+		disk.extents = append(disk.extents, extent)
+		disk.capacity += sectors
+
+		if err = extent.open(vmdkPath(dir, m[4]), m[0]); err != nil {
+			disk.Close()
+			return nil, fmt.Errorf("[ERROR] Unable to open extent %s of %s: %s", m[4], path, err)
+		}
+	}
+
+	if len(disk.extents) == 0 {
+		return nil, fmt.Errorf("[ERROR] No extents found in %s", path)
+	}
+
+	if parent := descriptorValue(descriptor, "parentCID"); parent != "" && !strings.EqualFold(parent, "ffffffff") {
+		hint := descriptorValue(descriptor, "parentFileNameHint")
+		if hint == "" {
+			disk.Close()
+			return nil, fmt.Errorf("[ERROR] Parent of delta disk %s is unknown", path)
+		}
+
+		if disk.parent, err = openVMDK(vmdkPath(dir, hint)); err != nil {
+			disk.Close()
+			return nil, err
+		}
+	}
+
+	return disk, nil
+}
+
+// Opens the file of the extent, reading the grain directory of sparse ones
+func (e *vmdkExtent) open(path, line string) error {
+	if e.kind == "ZERO" {
+		return nil
+	}
+
+	var err error
+	if e.file, err = os.Open(path); err != nil {
+		return err
+	}
+
+	switch e.kind {
+	case "FLAT", "VMFS":
+		// Flat extents may start at an offset of their file, ie: RW 2048 FLAT "disk-flat.vmdk" 0
+		fields := strings.Fields(line)
+		if offset, err := strconv.ParseUint(fields[len(fields)-1], 10, 64); err == nil {
+			e.offset = offset
+		}
+		return nil
+	case "SPARSE":
+	default:
+		return fmt.Errorf("unsupported extent type %s", e.kind)
+	}
+
+	e.header = new(sparseExtentHeader)
+	if err = binary.Read(io.NewSectionReader(e.file, 0, sectorSize), binary.LittleEndian, e.header); err != nil {
+		return err
+	}
+
+	if e.header.MagicNumber != vmdkMagic {
+		return fmt.Errorf("not a hosted sparse extent")
+	}
+
+	// Stream optimized disks end with the footer, followed by the end of
+	// stream marker, pointing to their grain directory
+	if e.header.GDOffset == vmdkGDAtEnd {
+		info, err := e.file.Stat()
+		if err != nil {
+			return err
+		}
+
+		e.header = new(sparseExtentHeader)
+		footer := io.NewSectionReader(e.file, info.Size()-2*sectorSize, sectorSize)
+		if err = binary.Read(footer, binary.LittleEndian, e.header); err != nil {
+			return err
+		}
+	}
+
+	e.gd = make([]uint32, e.header.numGTs())
+	return binary.Read(io.NewSectionReader(e.file, int64(e.header.GDOffset*sectorSize), int64(len(e.gd)*4)),
+		binary.LittleEndian, e.gd)
+}
+
+// Looks the grain table entry of sector up
+func (e *vmdkExtent) gte(sector uint64) (uint32, error) {
+	grain := sector / e.header.GrainSize
+	gtIndex := grain / uint64(e.header.NumGTEsPerGT)
+	if gtIndex >= uint64(len(e.gd)) || e.gd[gtIndex] == 0 {
+		return vmdkGTEUnallocated, nil
+	}
+
+	if e.gd[gtIndex] != e.gtOffset {
+		gt := make([]uint32, e.header.NumGTEsPerGT)
+		err := binary.Read(io.NewSectionReader(e.file, int64(e.gd[gtIndex])*sectorSize, int64(len(gt)*4)),
+			binary.LittleEndian, gt)
+		if err != nil {
+			return 0, err
+		}
+		e.gt, e.gtOffset = gt, e.gd[gtIndex]
+	}
+
+	return e.gt[grain%uint64(e.header.NumGTEsPerGT)], nil
+}
+
+// Reads a compressed grain, prefixed by its LBA and compressed size
+func (e *vmdkExtent) readCompressedGrain(gte uint32) ([]byte, error) {
+	marker := make([]byte, 12)
+	if _, err := e.file.ReadAt(marker, int64(gte)*sectorSize); err != nil {
+		return nil, err
+	}
+
+	compressed := make([]byte, binary.LittleEndian.Uint32(marker[8:]))
+	if _, err := e.file.ReadAt(compressed, int64(gte)*sectorSize+12); err != nil {
+		return nil, err
+	}
+
+	r, err := zlib.NewReader(bytes.NewReader(compressed))
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	grain := make([]byte, e.header.GrainSize*sectorSize)
+	if _, err = io.ReadFull(r, grain); err != nil {
+		return nil, err
+	}
+	return grain, nil
+}
+
+// Capacity of the disk in bytes
+func (d *vmdkReader) Size() uint64 {
+	return d.capacity * sectorSize
+}
+
+// Reads guest data at off, up to the end of the grain or extent it falls in.
+func (d *vmdkReader) readChunk(p []byte, off uint64) (int, error) {
+	sector := off / sectorSize
+
+	var extent *vmdkExtent
+	for _, e := range d.extents {
+		if sector >= e.start && sector < e.start+e.sectors {
+			extent = e
+			break
+		}
+	}
+
+	end := extent.start + extent.sectors
+	inExtent := off - extent.start*sectorSize
+	if extent.kind == "SPARSE" {
+		grainBytes := extent.header.GrainSize * sectorSize
+		if grainEnd := extent.start + (inExtent/grainBytes+1)*extent.header.GrainSize; grainEnd < end {
+			end = grainEnd
+		}
+	}
+
+	if n := end*sectorSize - off; uint64(len(p)) > n {
+		p = p[:n]
+	}
+
+	zero := func() (int, error) {
+		for i := range p {
+			p[i] = 0
+		}
+		return len(p), nil
+	}
+
+	switch extent.kind {
+	case "ZERO":
+		return zero()
+	case "FLAT", "VMFS":
+		return readPadded(extent.file, p, int64(extent.offset*sectorSize+inExtent))
+	}
+
+	gte, err := extent.gte(inExtent / sectorSize)
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case gte == vmdkGTEUnallocated && d.parent != nil:
+		// Parents end short of children grown after they were taken
+		n, err := d.parent.ReadAt(p, int64(off))
+		if err == io.EOF {
+			copy(p[n:], make([]byte, len(p)-n))
+			return len(p), nil
+		}
+		return n, err
+	case gte == vmdkGTEUnallocated || gte == vmdkGTEZero:
+		return zero()
+	case extent.header.Flags&vmdkFlagCompressed != 0:
+		grain, err := extent.readCompressedGrain(gte)
+		if err != nil {
+			return 0, err
+		}
+		return copy(p, grain[inExtent%(extent.header.GrainSize*sectorSize):]), nil
+	}
+
+	inGrain := inExtent % (extent.header.GrainSize * sectorSize)
+	return readPadded(extent.file, p, int64(gte)*sectorSize+int64(inGrain))
+}
+
+// Reads from file at off, past its end reads as zeros
+func readPadded(file *os.File, p []byte, off int64) (int, error) {
+	n, err := file.ReadAt(p, off)
+	if err == io.EOF {
+		for i := n; i < len(p); i++ {
+			p[i] = 0
+		}
+		return len(p), nil
+	}
+	return n, err
+}
+
+// Reads guest data at off, unallocated grains read as zeros
+func (d *vmdkReader) ReadAt(p []byte, off int64) (int, error) {
+	n := 0
+	for n < len(p) {
+		if uint64(off) >= d.Size() {
+			return n, io.EOF
+		}
+
+		read, err := d.readChunk(p[n:], uint64(off))
+		n += read
+		off += int64(read)
+		if err != nil {
+			return n, err
+		}
+	}
+	return n, nil
+}
+
+// Closes the files of the disk and of its parents
+func (d *vmdkReader) Close() error {
+	for _, extent := range d.extents {
+		if extent.file != nil {
+			extent.file.Close()
+		}
+	}
+
+	if d.parent != nil {
+		return d.parent.Close()
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package vix
+
+import (
+	"bufio"
+	"bytes"
+	"compress/zlib"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+
+	"github.com/dustin/go-humanize"
+)
+
+// Stream optimized extents, as found in OVF packages, are written front to
+// back: compressed grains prefixed by markers, then grain tables and the grain
+// directory, and a copy of the header, as a footer, pointing to the latter.
+const (
+	// Valid new line detection test, compressed grains and markers
+	vmdkStreamFlags        = 0x30001
+	vmdkCompressionDeflate = 1
+	// Grain directory offset of headers whose actual one is in the footer
+	vmdkGDAtEnd = ^uint64(0)
+)
+
+// Types of metadata markers
+const (
+	vmdkMarkerEOS = iota
+	vmdkMarkerGT
+	vmdkMarkerGD
+	vmdkMarkerFooter
+)
+
+// Marker preceding metadata in stream optimized extents
+type vmdkMetadataMarker struct {
+	NumSectors uint64
+	Size       uint32
+	Type       uint32
+	Pad        [496]byte
+}
+
+// Keeps track of the sector the stream is at, padding writes to whole sectors
+type sectorWriter struct {
+	w      *bufio.Writer
+	sector uint64
+}
+
+func (s *sectorWriter) write(data []byte) error {
+	padded := roundUp(uint64(len(data)), sectorSize)
+	if _, err := s.w.Write(data); err != nil {
+		return err
+	}
+
+	if _, err := s.w.Write(make([]byte, padded-uint64(len(data)))); err != nil {
+		return err
+	}
+
+	s.sector += padded / sectorSize
+	return nil
+}
+
+func (s *sectorWriter) writeStruct(data interface{}) error {
+	var buf bytes.Buffer
+	if err := binary.Write(&buf, binary.LittleEndian, data); err != nil {
+		return err
+	}
+	return s.write(buf.Bytes())
+}
+
+func (s *sectorWriter) writeMarker(numSectors uint64, markerType uint32) error {
+	return s.writeStruct(&vmdkMetadataMarker{NumSectors: numSectors, Type: markerType})
+}
+
+// Writes size bytes of disk data read from src as a stream optimized disk.
+// All-zero grains are left out.
+func writeStreamOptimizedVMDK(path string, src io.ReaderAt, size uint64, adapterType string, hwVersion int) error {
+	capacity := roundUp(size, sectorSize) / sectorSize
+
+	descriptor, err := newVMDKDescriptor("streamOptimized", filepath.Base(path), capacity, adapterType, hwVersion)
+	if err != nil {
+		return err
+	}
+
+	header := &sparseExtentHeader{
+		MagicNumber:        vmdkMagic,
+		Version:            3,
+		Flags:              vmdkStreamFlags,
+		Capacity:           capacity,
+		GrainSize:          vmdkGrainSize,
+		DescriptorOffset:   vmdkDescriptorOffset,
+		DescriptorSize:     vmdkDescriptorSize,
+		NumGTEsPerGT:       vmdkGTEsPerGT,
+		GDOffset:           vmdkGDAtEnd,
+		OverHead:           vmdkGrainSize,
+		SingleEndLineChar:  '\n',
+		NonEndLineChar:     ' ',
+		DoubleEndLineChar1: '\r',
+		DoubleEndLineChar2: '\n',
+		CompressAlgorithm:  vmdkCompressionDeflate,
+	}
+
+	if len(descriptor) > int(header.DescriptorSize*sectorSize) {
+		return fmt.Errorf("[ERROR] Disk descriptor is too large: %d bytes", len(descriptor))
+	}
+
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	log.Printf("[DEBUG] Writing %s stream optimized disk %s", humanize.IBytes(size), path)
+
+	s := &sectorWriter{w: bufio.NewWriter(file)}
+	if err = s.writeStruct(header); err != nil {
+		return err
+	}
+
+	if err = s.write([]byte(descriptor)); err != nil {
+		return err
+	}
+
+	if err = s.write(make([]byte, (header.OverHead-s.sector)*sectorSize)); err != nil {
+		return err
+	}
+
+	gtes := make([]uint32, header.numGTs()*uint64(header.NumGTEsPerGT))
+	grain := make([]byte, header.GrainSize*sectorSize)
+	zero := make([]byte, len(grain))
+
+	var compressed bytes.Buffer
+	for i := range gtes {
+		offset := uint64(i) * uint64(len(grain))
+		if offset >= size {
+			break
+		}
+
+		n, err := src.ReadAt(grain, int64(offset))
+		if err != nil && err != io.EOF {
+			return err
+		}
+		copy(grain[n:], zero)
+
+		if bytes.Equal(grain, zero) {
+			continue
+		}
+
+		compressed.Reset()
+		zw := zlib.NewWriter(&compressed)
+		if _, err = zw.Write(grain); err != nil {
+			return err
+		}
+		if err = zw.Close(); err != nil {
+			return err
+		}
+
+		// Grain markers carry the LBA of the grain and its compressed size
+		marker := make([]byte, 12, 12+compressed.Len())
+		binary.LittleEndian.PutUint64(marker, offset/sectorSize)
+		binary.LittleEndian.PutUint32(marker[8:], uint32(compressed.Len()))
+
+		gtes[i] = uint32(s.sector)
+		if err = s.write(append(marker, compressed.Bytes()...)); err != nil {
+			return err
+		}
+	}
+
+	// Only grain tables with allocated grains are written
+	gd := make([]uint32, header.numGTs())
+	for i := range gd {
+		gt := gtes[i*vmdkGTEsPerGT : (i+1)*vmdkGTEsPerGT]
+		if bytes.Equal(uint32sToBytes(gt), make([]byte, len(gt)*4)) {
+			continue
+		}
+
+		if err = s.writeMarker(header.gtSize(), vmdkMarkerGT); err != nil {
+			return err
+		}
+
+		gd[i] = uint32(s.sector)
+		if err = s.write(uint32sToBytes(gt)); err != nil {
+			return err
+		}
+	}
+
+	if err = s.writeMarker(header.gdSectors(), vmdkMarkerGD); err != nil {
+		return err
+	}
+
+	footer := *header
+	footer.GDOffset = s.sector
+	if err = s.write(uint32sToBytes(gd)); err != nil {
+		return err
+	}
+
+	if err = s.writeMarker(1, vmdkMarkerFooter); err != nil {
+		return err
+	}
+
+	if err = s.writeStruct(&footer); err != nil {
+		return err
+	}
+
+	if err = s.writeMarker(0, vmdkMarkerEOS); err != nil {
+		return err
+	}
+
+	return s.w.Flush()
+}
+
+// Encodes grain tables and directories
+func uint32sToBytes(values []uint32) []byte {
+	buf := make([]byte, len(values)*4)
+	for i, v := range values {
+		binary.LittleEndian.PutUint32(buf[i*4:], v)
+	}
+	return buf
+}
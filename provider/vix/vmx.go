@@ -0,0 +1,85 @@
+package vix
+
+import (
+	"bytes"
+	"io/ioutil"
+	"sort"
+	"strings"
+)
+
+// Reads a VMX file into a map. Keys are lowercased since VMware treats them in
+// a case-insensitive manner and GoVMX writes them lowercased anyway.
+//
+// We do not use GoVMX here because it only round-trips the keys it knows
+// about, and some of the settings managed by this provider are not modeled by
+// it.
+func readVMX(path string) (map[string]string, error) {
+	vmx, _, err := readVMXKeys(path)
+	return vmx, err
+}
+
+// Same as readVMX, but it also returns how keys are spelled in the file,
+// indexed by their lowercased version.
+func readVMXKeys(path string) (vmx, spelling map[string]string, err error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	vmx = make(map[string]string)
+	spelling = make(map[string]string)
+
+	for _, line := range strings.Split(string(data), "\n") {
+		values := strings.SplitN(line, "=", 2)
+		if len(values) == 2 {
+			key := strings.TrimSpace(values[0])
+			vmx[strings.ToLower(key)] = strings.Trim(strings.TrimSpace(values[1]), `"`)
+			spelling[strings.ToLower(key)] = key
+		}
+	}
+
+	return vmx, spelling, nil
+}
+
+// Writes a VMX map down to disk, sorting keys so the output is stable.
+func writeVMX(path string, vmx map[string]string) error {
+	return writeVMXKeys(path, vmx, nil)
+}
+
+// Same as writeVMX, but keys found in spelling are written the way they are
+// spelled there.
+func writeVMXKeys(path string, vmx, spelling map[string]string) error {
+	keys := make([]string, 0, len(vmx))
+	for k := range vmx {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	var buf bytes.Buffer
+	for _, key := range keys {
+		name := key
+		if spelled, ok := spelling[key]; ok {
+			name = spelled
+		}
+		buf.WriteString(name + ` = "` + vmx[key] + `"` + "\n")
+	}
+
+	return ioutil.WriteFile(path, buf.Bytes(), 0644)
+}
+
+// Reads a VMX file, hands it over to updateFunc and writes it back to disk.
+// Keys keep their original spelling, since GoVIX looks some of them up
+// case-sensitively, such as ethernetN.connectionType. The virtual machine has
+// to be powered off.
+func updateVMX(path string, updateFunc func(vmx map[string]string) error) error {
+	vmx, spelling, err := readVMXKeys(path)
+	if err != nil {
+		return err
+	}
+
+	if err = updateFunc(vmx); err != nil {
+		return err
+	}
+
+	return writeVMXKeys(path, vmx, spelling)
+}
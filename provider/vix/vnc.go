@@ -0,0 +1,194 @@
+package vix
+
+import (
+	"crypto/rand"
+	"fmt"
+	"log"
+	"math/big"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Ports VNC servers of virtual machines are assigned from
+const (
+	vncFirstPort = 5900
+	vncLastPort  = 5999
+)
+
+// VNC authentication only uses the first 8 characters of passwords
+const vncPasswordLength = 8
+
+// Characters of generated passwords, ambiguous ones such as 0, O, 1 and l are
+// left out
+const vncPasswordChars = "abcdefghijkmnopqrstuvwxyzABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// Prefix of VNC settings in VMX files
+const vncKeyPrefix = "remotedisplay.vnc."
+
+// VNC server of the virtual machine console
+type VNC struct {
+	// TCP port, a free one is assigned if zero
+	Port uint
+	// Password, one is generated if empty
+	Password string
+	// Address to listen on, all addresses if empty
+	IP string
+	// Keyboard layout, such as us or de
+	KeyMap string
+}
+
+// Address VNC clients connect to
+func (c *VNC) Endpoint() string {
+	host := c.IP
+	if host == "" || host == "0.0.0.0" {
+		host = "127.0.0.1"
+	}
+	return "vnc://" + net.JoinHostPort(host, strconv.Itoa(int(c.Port)))
+}
+
+// Enables the VNC server, assigning it a port and password if not set, or
+// disables it if VNC is nil. The port and password assigned before are kept,
+// they are read from current, the VMX file as it was before updating it.
+func (v *VM) writeVNC(vmxFile string, current map[string]string) error {
+	if v.VNC == nil {
+		return updateVMX(vmxFile, func(vmx map[string]string) error {
+			for key := range vmx {
+				if strings.HasPrefix(key, vncKeyPrefix) {
+					delete(vmx, key)
+				}
+			}
+			return nil
+		})
+	}
+
+	taken, err := vncPortsInUse(vmxFile)
+	if err != nil {
+		return err
+	}
+
+	vnc := v.VNC
+	if vnc.Port == 0 {
+		// Keeps the port assigned before, unless another VM took it meanwhile
+		port, _ := strconv.Atoi(current[vncKeyPrefix+"port"])
+		if _, ok := taken[uint(port)]; !ok {
+			vnc.Port = uint(port)
+		}
+	}
+
+	if vnc.Port, err = allocateVNCPort(vnc.Port, vnc.IP, taken); err != nil {
+		return err
+	}
+
+	if vnc.Password == "" {
+		vnc.Password = current[vncKeyPrefix+"password"]
+	}
+	if vnc.Password == "" {
+		if vnc.Password, err = generateVNCPassword(); err != nil {
+			return err
+		}
+	}
+
+	log.Printf("[DEBUG] Enabling VNC server on %s", vnc.Endpoint())
+	return updateVMX(vmxFile, func(vmx map[string]string) error {
+		vmx[vncKeyPrefix+"enabled"] = "TRUE"
+		vmx[vncKeyPrefix+"port"] = strconv.Itoa(int(vnc.Port))
+		vmx[vncKeyPrefix+"password"] = vnc.Password
+
+		delete(vmx, vncKeyPrefix+"ip")
+		if vnc.IP != "" {
+			vmx[vncKeyPrefix+"ip"] = vnc.IP
+		}
+
+		delete(vmx, vncKeyPrefix+"keymap")
+		if vnc.KeyMap != "" {
+			vmx[vncKeyPrefix+"keymap"] = vnc.KeyMap
+		}
+
+		return nil
+	})
+}
+
+// Reads the VNC settings out of the VMX file, nil if the server is disabled
+func readVNC(vmx map[string]string) *VNC {
+	if !strings.EqualFold(vmx[vncKeyPrefix+"enabled"], "true") {
+		return nil
+	}
+
+	port, _ := strconv.Atoi(vmx[vncKeyPrefix+"port"])
+	return &VNC{
+		Port:     uint(port),
+		Password: vmx[vncKeyPrefix+"password"],
+		IP:       vmx[vncKeyPrefix+"ip"],
+		KeyMap:   vmx[vncKeyPrefix+"keymap"],
+	}
+}
+
+// Maps the VNC ports of the other managed virtual machines to their vmx
+// files.
+func vncPortsInUse(vmxFile string) (map[uint]string, error) {
+	files, err := managedVMXFiles()
+	if err != nil {
+		return nil, err
+	}
+
+	taken := make(map[uint]string)
+	for _, file := range files {
+		if file == vmxFile {
+			continue
+		}
+
+		vmx, err := readVMX(file)
+		if err != nil {
+			return nil, err
+		}
+
+		if vnc := readVNC(vmx); vnc != nil && vnc.Port != 0 {
+			taken[vnc.Port] = file
+		}
+	}
+
+	return taken, nil
+}
+
+// Makes sure the port is not used by other managed virtual machines, or
+// finds a free one if it is zero. Free ports can not be bound by other
+// processes either.
+func allocateVNCPort(port uint, ip string, taken map[uint]string) (uint, error) {
+	if port != 0 {
+		if file, ok := taken[port]; ok {
+			return 0, fmt.Errorf("[ERROR] VNC port %d is already used by %s", port, file)
+		}
+		return port, nil
+	}
+
+	for port = vncFirstPort; port <= vncLastPort; port++ {
+		if _, ok := taken[port]; ok {
+			continue
+		}
+
+		l, err := net.Listen("tcp", net.JoinHostPort(ip, strconv.Itoa(int(port))))
+		if err != nil {
+			continue
+		}
+		l.Close()
+
+		return port, nil
+	}
+
+	return 0, fmt.Errorf("[ERROR] There are no free VNC ports between %d and %d", vncFirstPort, vncLastPort)
+}
+
+// Generates a random password, of the length VNC authentication supports
+func generateVNCPassword() (string, error) {
+	max := big.NewInt(int64(len(vncPasswordChars)))
+	password := make([]byte, vncPasswordLength)
+	for i := range password {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		password[i] = vncPasswordChars[n.Int64()]
+	}
+	return string(password), nil
+}
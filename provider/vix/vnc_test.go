@@ -0,0 +1,66 @@
+package vix
+
+import (
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+func TestAllocateVNCPort(t *testing.T) {
+	taken := map[uint]string{vncFirstPort: "/vms/web01/web01.vmx"}
+
+	_, err := allocateVNCPort(vncFirstPort, "127.0.0.1", taken)
+	assert(t, err != nil, "port %d is taken by web01", vncFirstPort)
+
+	port, err := allocateVNCPort(5910, "127.0.0.1", taken)
+	ok(t, err)
+	equals(t, uint(5910), port)
+
+	// Ports bound by other processes are skipped as well
+	l, err := net.Listen("tcp", net.JoinHostPort("127.0.0.1", strconv.Itoa(vncFirstPort+1)))
+	ok(t, err)
+	defer l.Close()
+
+	port, err = allocateVNCPort(0, "127.0.0.1", taken)
+	ok(t, err)
+	assert(t, port > vncFirstPort+1 && port <= vncLastPort, "port %d should not be assigned", port)
+}
+
+func TestWriteVNC(t *testing.T) {
+	dir, err := ioutil.TempDir(os.TempDir(), "terraform-vix")
+	ok(t, err)
+	defer os.RemoveAll(dir)
+
+	vmxFile := filepath.Join(dir, "ci01.vmx")
+	ok(t, writeVMX(vmxFile, map[string]string{"memsize": "1024"}))
+
+	v := &VM{VNC: &VNC{IP: "127.0.0.1", KeyMap: "de"}}
+	current := map[string]string{"remotedisplay.vnc.port": "5920"}
+	ok(t, v.writeVNC(vmxFile, current))
+
+	vmx, err := readVMX(vmxFile)
+	ok(t, err)
+	vnc := readVNC(vmx)
+	assert(t, vnc != nil, "VNC should be enabled")
+	equals(t, uint(5920), vnc.Port)
+	equals(t, "de", vnc.KeyMap)
+	equals(t, vncPasswordLength, len(vnc.Password))
+	equals(t, v.VNC.Password, vnc.Password)
+	equals(t, "vnc://127.0.0.1:5920", vnc.Endpoint())
+
+	// The password is kept across updates
+	v.VNC = &VNC{}
+	ok(t, v.writeVNC(vmxFile, vmx))
+	equals(t, vnc.Password, v.VNC.Password)
+
+	v.VNC = nil
+	ok(t, v.writeVNC(vmxFile, vmx))
+
+	vmx, err = readVMX(vmxFile)
+	ok(t, err)
+	assert(t, readVNC(vmx) == nil, "VNC should be disabled")
+	equals(t, map[string]string{"memsize": "1024"}, vmx)
+}
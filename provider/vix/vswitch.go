@@ -1,5 +1,14 @@
 package vix
 
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"runtime"
+)
+
+var vmnetRegexp = regexp.MustCompile(`^vmnet[0-9]+$`)
+
 type VSwitch struct {
 	// Name for this switch
 	Name string
@@ -18,3 +27,23 @@ func (v *VSwitch) Create()  {}
 func (v *VSwitch) Destroy() {}
 func (v *VSwitch) Refresh() {}
 func (v *VSwitch) Update()  {}
+
+// Normalizes a virtual switch reference to its vmnet name. References can be
+// either a vmnet name like "vmnet2", its device path, "/dev/vmnet2", or the ID
+// of a vix_vswitch resource, which is its vmnet name as well.
+func VSwitchName(ref string) (string, error) {
+	name := filepath.Base(ref)
+	if !vmnetRegexp.MatchString(name) {
+		return "", fmt.Errorf("[ERROR] Invalid virtual switch %q, it has to be a vmnet name such as vmnet2", ref)
+	}
+	return name, nil
+}
+
+// Returns the value VMware expects in ethernetN.vnet for the given vmnet name.
+// Linux hosts reference vmnets through their device path.
+func vnetValue(name string) string {
+	if runtime.GOOS == "linux" {
+		return "/dev/" + name
+	}
+	return name
+}
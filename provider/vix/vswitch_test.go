@@ -0,0 +1,20 @@
+package vix
+
+import "testing"
+
+func TestVSwitchName(t *testing.T) {
+	for ref, exp := range map[string]string{
+		"vmnet2":       "vmnet2",
+		"vmnet10":      "vmnet10",
+		"/dev/vmnet10": "vmnet10",
+	} {
+		name, err := VSwitchName(ref)
+		ok(t, err)
+		equals(t, exp, name)
+	}
+
+	for _, ref := range []string{"", "vmnet", "custom", "eth0"} {
+		_, err := VSwitchName(ref)
+		assert(t, err != nil, "%q should not be a valid vswitch", ref)
+	}
+}